@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/qew21/fb-messenger/messenger"
+
+	"github.com/rs/zerolog/log"
+)
+
+// requireAdmin guards admin endpoints with the ADMIN_TOKEN bearer token. The
+// endpoints are disabled when no token is configured.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if appConfig.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(appConfig.AdminToken)) != 1 {
+			log.Warn().Str("path", r.URL.Path).Msg("Rejected admin request")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func handleGetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := deadLetters.List()
+	if err != nil {
+		log.Warn().Err(err).Msg("Error listing dead letters")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(letters)
+}
+
+type replayRequest struct {
+	IDs []string `json:"ids"`
+	All bool     `json:"all"`
+}
+
+func handleReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	var request replayRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		handleJSONUnmarshalError(w, err)
+		return
+	}
+	if len(request.IDs) == 0 && !request.All {
+		http.Error(w, "ids or all is required", http.StatusBadRequest)
+		return
+	}
+
+	results, err := messenger.ReplayDeadLetters(deadLetters, request.IDs, appConfig)
+	if err != nil {
+		log.Warn().Err(err).Msg("Error replaying dead letters")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// runCommand runs a CLI subcommand instead of the server and returns the
+// process exit code.
+func runCommand(name string, args []string) int {
+	switch name {
+	case "replay":
+		return runReplay(args)
+	case "train":
+		return runTrain(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		return 2
+	}
+}
+
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	all := flags.Bool("all", false, "replay every dead letter")
+	list := flags.Bool("list", false, "list dead letters without replaying them")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: replay [-list] [-all] [id ...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *list {
+		letters, err := deadLetters.List()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, letter := range letters {
+			fmt.Printf("%s\t%s\t%s\tattempts=%d replays=%d\t%s\n", letter.ID, letter.FailedAt.Format(time.RFC3339), letter.Recipient, letter.Attempts, letter.Replays, letter.Error)
+		}
+		return 0
+	}
+	if flags.NArg() == 0 && !*all {
+		flags.Usage()
+		return 2
+	}
+
+	results, err := messenger.ReplayDeadLetters(deadLetters, flags.Args(), appConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	code := 0
+	for _, result := range results {
+		if result.Delivered {
+			fmt.Printf("%s\tdelivered\n", result.ID)
+		} else {
+			fmt.Printf("%s\tfailed\t%s\n", result.ID, result.Error)
+			code = 1
+		}
+	}
+	return code
+}
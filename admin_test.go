@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/qew21/fb-messenger/config"
+	"github.com/qew21/fb-messenger/fakegraph"
+	"github.com/qew21/fb-messenger/messenger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminDeadLetters(t *testing.T) {
+	setupTestConfiguration()
+	server := fakegraph.NewServer()
+	defer server.Close()
+
+	testConfig := *appConfig
+	defer func(original *config.AppConfig, store messenger.DeadLetterStore) {
+		appConfig = original
+		deadLetters = store
+	}(appConfig, deadLetters)
+	testConfig.GraphAPIURL = server.URL
+	testConfig.AdminToken = "admin_secret"
+	appConfig = &testConfig
+	deadLetters = messenger.NewFileDeadLetterStore(filepath.Join(t.TempDir(), "deadletters.json"))
+
+	letter, err := deadLetters.Add(messenger.DeadLetter{
+		Recipient: "44444444",
+		Payload:   []byte(`{"recipient":{"id":"44444444"},"message":{"text":"hello"},"messaging_type":"RESPONSE"}`),
+		Error:     "outside window",
+	})
+	require.NoError(t, err)
+
+	list := requireAdmin(handleGetDeadLetters)
+	recorder := httptest.NewRecorder()
+	list(recorder, httptest.NewRequest(http.MethodGet, "/admin/deadletters", nil))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/deadletters", nil)
+	req.Header.Set("Authorization", "Bearer admin_secret")
+	recorder = httptest.NewRecorder()
+	list(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var letters []messenger.DeadLetter
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &letters))
+	require.Len(t, letters, 1)
+	assert.Equal(t, letter.ID, letters[0].ID)
+
+	replay := requireAdmin(handleReplayDeadLetters)
+	req = httptest.NewRequest(http.MethodPost, "/admin/deadletters/replay", bytes.NewBufferString(`{}`))
+	req.Header.Set("Authorization", "Bearer admin_secret")
+	recorder = httptest.NewRecorder()
+	replay(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/deadletters/replay", bytes.NewBufferString(`{"ids":["`+letter.ID+`"]}`))
+	req.Header.Set("Authorization", "Bearer admin_secret")
+	recorder = httptest.NewRecorder()
+	replay(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var results []messenger.ReplayResult
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &results))
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Delivered)
+	require.Len(t, server.Messages(), 1)
+	assert.Equal(t, "hello", server.Messages()[0].Text)
+
+	assert.Equal(t, 2, runCommand("replay", nil))
+	assert.Equal(t, 0, runCommand("replay", []string{"-list"}))
+	assert.Equal(t, 2, runCommand("unknown", nil))
+}
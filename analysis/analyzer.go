@@ -0,0 +1,162 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/qew21/fb-messenger/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Sentiment analyzers selectable with SENTIMENT_ANALYZER.
+const (
+	AnalyzerHTTP     = "http"
+	AnalyzerLexicon  = "lexicon"
+	AnalyzerBayes    = "bayes"
+	AnalyzerFallback = "fallback"
+)
+
+// Analyzer scores the sentiment of a text.
+type Analyzer interface {
+	Analyze(ctx context.Context, text string) (PredictionResult, error)
+}
+
+// FromConfig returns the analyzer selected by SENTIMENT_ANALYZER, routing
+// texts in the languages of SENTIMENT_LANGUAGES to their own analyzers. The
+// fallback analyzer uses the trained model at SENTIMENT_MODEL_PATH when there
+// is one, and the lexicon otherwise. Results are cached when
+// SENTIMENT_CACHE_SIZE is positive.
+func FromConfig(appConfig *config.AppConfig) (Analyzer, error) {
+	analyzer, err := analyzersFromConfig(appConfig)
+	if err != nil || appConfig.SentimentCacheSize <= 0 {
+		return analyzer, err
+	}
+	return NewCachedAnalyzer(analyzer, appConfig.SentimentCacheSize), nil
+}
+
+func analyzersFromConfig(appConfig *config.AppConfig) (Analyzer, error) {
+	remote := predictorFromConfig(appConfig, appConfig.PredictUrl)
+	analyzer, err := newAnalyzer(appConfig.SentimentAnalyzer, remote, appConfig.SentimentModel, appConfig.SentimentTimeout, "")
+	if err != nil || len(appConfig.SentimentLanguages) == 0 {
+		return analyzer, err
+	}
+
+	router := &LanguageRouter{Analyzers: make(map[string]Analyzer), Default: analyzer}
+	for language, selected := range appConfig.SentimentLanguages {
+		kind := selected.Analyzer
+		if kind == "" {
+			kind = appConfig.SentimentAnalyzer
+		}
+		remote := predictorFromConfig(appConfig, selected.PredictUrl)
+		router.Analyzers[language], err = newAnalyzer(kind, remote, selected.ModelPath, appConfig.SentimentTimeout, language)
+		if err != nil {
+			return nil, fmt.Errorf("%s sentiment analyzer: %w", language, err)
+		}
+	}
+	return router, nil
+}
+
+// predictorFromConfig returns the predictor at predictUrl, or at PREDICT_URL
+// when it is empty. PREDICT_BATCH_URL only applies to the PREDICT_URL
+// predictor.
+func predictorFromConfig(appConfig *config.AppConfig, predictUrl string) *HTTPAnalyzer {
+	remote := NewHTTPAnalyzer(predictUrl, appConfig.SentimentTimeout)
+	if predictUrl == "" || predictUrl == appConfig.PredictUrl {
+		remote.URL = appConfig.PredictUrl
+		remote.BatchURL = appConfig.PredictBatchUrl
+	}
+	if appConfig.SentimentBatchSize > 0 {
+		remote.BatchSize = appConfig.SentimentBatchSize
+	}
+	return remote
+}
+
+func newAnalyzer(kind string, remote *HTTPAnalyzer, modelPath string, timeout time.Duration, language string) (Analyzer, error) {
+	switch kind {
+	case AnalyzerHTTP:
+		return remote, nil
+	case AnalyzerLexicon:
+		return lexiconFor(language), nil
+	case AnalyzerBayes:
+		return LoadNaiveBayes(modelPath)
+	case AnalyzerFallback, "":
+		var local Analyzer = lexiconFor(language)
+		if modelPath != "" {
+			model, err := LoadNaiveBayes(modelPath)
+			if err == nil {
+				local = model
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+		return NewFallbackAnalyzer(remote, local, timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown sentiment analyzer %q", kind)
+	}
+}
+
+func lexiconFor(language string) *LexiconAnalyzer {
+	if language == LanguageChinese {
+		return NewChineseLexiconAnalyzer()
+	}
+	return NewLexiconAnalyzer()
+}
+
+// FallbackAnalyzer uses Primary, typically a remote predictor, and answers
+// with Fallback when it fails or takes longer than Timeout. Fallback results
+// are not cached, so texts are scored again once Primary recovers.
+type FallbackAnalyzer struct {
+	Primary  Analyzer
+	Fallback Analyzer
+	Timeout  time.Duration
+}
+
+func NewFallbackAnalyzer(primary Analyzer, fallback Analyzer, timeout time.Duration) *FallbackAnalyzer {
+	return &FallbackAnalyzer{Primary: primary, Fallback: fallback, Timeout: timeout}
+}
+
+func (a *FallbackAnalyzer) Analyze(ctx context.Context, text string) (PredictionResult, error) {
+	primaryCtx := ctx
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		primaryCtx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+
+	result, err := a.Primary.Analyze(primaryCtx, text)
+	if err == nil {
+		return result, nil
+	}
+	// Only the primary's deadline passed; the caller's context still rules.
+	if ctx.Err() != nil {
+		return PredictionResult{}, ctx.Err()
+	}
+
+	log.Warn().Err(err).Msg("Sentiment analyzer failed, using fallback")
+	result, err = a.Fallback.Analyze(ctx, text)
+	result.fallback = true
+	return result, err
+}
+
+// AnalyzeBatch scores texts with Primary and, when it fails, all of them
+// with Fallback. Timeout is not applied to the batch as a whole, which may
+// take several requests; a primary predictor bounds each of its own.
+func (a *FallbackAnalyzer) AnalyzeBatch(ctx context.Context, texts []string) ([]PredictionResult, error) {
+	results, err := AnalyzeBatch(ctx, a.Primary, texts)
+	if err == nil {
+		return results, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	log.Warn().Err(err).Int("texts", len(texts)).Msg("Sentiment analyzer failed, using fallback")
+	results, err = AnalyzeBatch(ctx, a.Fallback, texts)
+	for i := range results {
+		results[i].fallback = true
+	}
+	return results, err
+}
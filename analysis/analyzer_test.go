@@ -0,0 +1,158 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qew21/fb-messenger/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubAnalyzer struct {
+	result PredictionResult
+	err    error
+	calls  int
+}
+
+func (a *stubAnalyzer) Analyze(ctx context.Context, text string) (PredictionResult, error) {
+	a.calls++
+	return a.result, a.err
+}
+
+func TestHTTPAnalyzer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]string
+		json.NewDecoder(r.Body).Decode(&request)
+		if request["text"] == "" {
+			http.Error(w, "no text", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(PredictionResult{Prediction: Positive, Probability: 0.8})
+	}))
+	defer server.Close()
+
+	analyzer := NewHTTPAnalyzer(server.URL, time.Second)
+	result, err := analyzer.Analyze(context.Background(), "great")
+	require.NoError(t, err)
+	assert.Equal(t, PredictionResult{Prediction: Positive, Probability: 0.8}, result)
+
+	_, err = analyzer.Analyze(context.Background(), "")
+	assert.ErrorContains(t, err, "status code 400")
+}
+
+func TestHTTPAnalyzerBatch(t *testing.T) {
+	var batches, singles int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/predict", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&singles, 1)
+		json.NewEncoder(w).Encode(PredictionResult{Prediction: Neutral, Probability: 0.5})
+	})
+	mux.HandleFunc("/batch", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&batches, 1)
+		var request struct{ Texts []string }
+		json.NewDecoder(r.Body).Decode(&request)
+		results := make([]PredictionResult, len(request.Texts))
+		for i, text := range request.Texts {
+			results[i] = PredictionResult{Prediction: text, Probability: 1}
+		}
+		json.NewEncoder(w).Encode(results)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	texts := []string{Positive, Negative, Neutral, Positive, Negative}
+	analyzer := NewHTTPAnalyzer(server.URL+"/predict", time.Second)
+	analyzer.BatchURL = server.URL + "/batch"
+	analyzer.BatchSize = 2
+	results, err := analyzer.AnalyzeBatch(context.Background(), texts)
+	require.NoError(t, err)
+	require.Len(t, results, len(texts))
+	for i, text := range texts {
+		assert.Equal(t, text, results[i].Prediction)
+	}
+	assert.EqualValues(t, 3, batches)
+	assert.EqualValues(t, 0, singles)
+
+	// Predictors without a batch endpoint get one request per text.
+	analyzer.BatchURL = server.URL + "/missing"
+	results, err = analyzer.AnalyzeBatch(context.Background(), texts)
+	require.NoError(t, err)
+	require.Len(t, results, len(texts))
+	assert.Equal(t, Neutral, results[4].Prediction)
+	assert.EqualValues(t, len(texts), singles)
+}
+
+func TestFallbackAnalyzer(t *testing.T) {
+	primary := &stubAnalyzer{result: PredictionResult{Prediction: Negative, Probability: 0.9}}
+	fallback := &stubAnalyzer{result: PredictionResult{Prediction: Neutral, Probability: 1}}
+	analyzer := NewFallbackAnalyzer(primary, fallback, time.Second)
+
+	result, err := analyzer.Analyze(context.Background(), "text")
+	require.NoError(t, err)
+	assert.Equal(t, Negative, result.Prediction)
+	assert.Equal(t, 0, fallback.calls)
+
+	primary.err = errors.New("connection refused")
+	result, err = analyzer.Analyze(context.Background(), "text")
+	require.NoError(t, err)
+	assert.Equal(t, Neutral, result.Prediction)
+	assert.Equal(t, 1, fallback.calls)
+}
+
+func TestFallbackAnalyzerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	analyzer := NewFallbackAnalyzer(NewHTTPAnalyzer(server.URL, 0), NewLexiconAnalyzer(), 50*time.Millisecond)
+	started := time.Now()
+	result, err := analyzer.Analyze(context.Background(), "This film is not terrible!")
+	require.NoError(t, err)
+	assert.Equal(t, Positive, result.Prediction)
+	assert.Less(t, time.Since(started), time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = analyzer.Analyze(ctx, "text")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestFromConfig(t *testing.T) {
+	for kind, expected := range map[string]Analyzer{
+		AnalyzerHTTP:     &HTTPAnalyzer{},
+		AnalyzerLexicon:  &LexiconAnalyzer{},
+		AnalyzerFallback: &FallbackAnalyzer{},
+	} {
+		analyzer, err := FromConfig(&config.AppConfig{SentimentAnalyzer: kind, PredictUrl: "http://127.0.0.1:5000/predict"})
+		require.NoError(t, err)
+		assert.IsType(t, expected, analyzer)
+	}
+
+	_, err := FromConfig(&config.AppConfig{SentimentAnalyzer: "magic"})
+	assert.Error(t, err)
+	_, err = FromConfig(&config.AppConfig{SentimentAnalyzer: AnalyzerBayes, SentimentModel: filepath.Join(t.TempDir(), "missing.json")})
+	assert.Error(t, err)
+
+	// The fallback prefers a trained model over the lexicon.
+	modelPath := filepath.Join(t.TempDir(), "model.json")
+	model := NewNaiveBayes()
+	model.Train(trainingExamples)
+	require.NoError(t, model.Save(modelPath))
+	analyzer, err := FromConfig(&config.AppConfig{SentimentAnalyzer: AnalyzerFallback, SentimentModel: modelPath})
+	require.NoError(t, err)
+	assert.IsType(t, &NaiveBayes{}, analyzer.(*FallbackAnalyzer).Fallback)
+	analyzer, err = FromConfig(&config.AppConfig{SentimentAnalyzer: AnalyzerBayes, SentimentModel: modelPath})
+	require.NoError(t, err)
+	assert.IsType(t, &NaiveBayes{}, analyzer)
+}
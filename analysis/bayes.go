@@ -0,0 +1,175 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NaiveBayes is a multinomial Naive Bayes text classifier with Laplace
+// smoothing. Words after a negation are counted as separate "not_" features
+// so "not good" does not look like "good". Train must not run concurrently
+// with Analyze.
+type NaiveBayes struct {
+	Docs   map[string]int            `json:"docs"`
+	Words  map[string]map[string]int `json:"words"`
+	Totals map[string]int            `json:"totals"`
+
+	vocabulary int
+}
+
+// Example is a labeled text used for training and evaluation.
+type Example struct {
+	Text  string `json:"text"`
+	Label string `json:"label"`
+}
+
+func NewNaiveBayes() *NaiveBayes {
+	return &NaiveBayes{
+		Docs:   make(map[string]int),
+		Words:  make(map[string]map[string]int),
+		Totals: make(map[string]int),
+	}
+}
+
+// LoadNaiveBayes reads a model written by Save.
+func LoadNaiveBayes(path string) (*NaiveBayes, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sentiment model: %w", err)
+	}
+	model := NewNaiveBayes()
+	if err := json.Unmarshal(data, model); err != nil {
+		return nil, fmt.Errorf("failed to decode sentiment model %s: %w", path, err)
+	}
+	if len(model.Docs) == 0 {
+		return nil, fmt.Errorf("sentiment model %s has no classes", path)
+	}
+	model.countVocabulary()
+	return model, nil
+}
+
+func (m *NaiveBayes) Save(path string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode sentiment model: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create sentiment model directory: %w", err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write sentiment model: %w", err)
+	}
+	return nil
+}
+
+func (m *NaiveBayes) Train(examples []Example) {
+	for _, example := range examples {
+		label := normalizeLabel(example.Label)
+		if label == "" {
+			continue
+		}
+		m.Docs[label]++
+		if m.Words[label] == nil {
+			m.Words[label] = make(map[string]int)
+		}
+		for _, feature := range features(example.Text) {
+			m.Words[label][feature]++
+			m.Totals[label]++
+		}
+	}
+	m.countVocabulary()
+}
+
+// Classes returns the labels the model was trained on, sorted.
+func (m *NaiveBayes) Classes() []string {
+	classes := make([]string, 0, len(m.Docs))
+	for label := range m.Docs {
+		classes = append(classes, label)
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+func (m *NaiveBayes) Analyze(ctx context.Context, text string) (PredictionResult, error) {
+	if len(m.Docs) == 0 {
+		return PredictionResult{}, fmt.Errorf("sentiment model is not trained")
+	}
+	return m.Predict(text), nil
+}
+
+// Predict returns the most likely label with its posterior probability.
+func (m *NaiveBayes) Predict(text string) PredictionResult {
+	documents := 0
+	for _, count := range m.Docs {
+		documents += count
+	}
+	textFeatures := features(text)
+
+	classes := m.Classes()
+	scores := make([]float64, len(classes))
+	best := 0
+	for i, label := range classes {
+		score := math.Log(float64(m.Docs[label]) / float64(documents))
+		denominator := float64(m.Totals[label] + m.vocabulary)
+		for _, feature := range textFeatures {
+			score += math.Log(float64(m.Words[label][feature]+1) / denominator)
+		}
+		scores[i] = score
+		if score > scores[best] {
+			best = i
+		}
+	}
+
+	// Normalize the log scores into probabilities without underflowing.
+	sum := 0.0
+	for _, score := range scores {
+		sum += math.Exp(score - scores[best])
+	}
+	probabilities := make(map[string]float64, len(classes))
+	for i, label := range classes {
+		probabilities[label] = math.Exp(scores[i]-scores[best]) / sum
+	}
+	return PredictionResult{Prediction: classes[best], Probability: 1 / sum, Scores: probabilities}
+}
+
+func (m *NaiveBayes) countVocabulary() {
+	vocabulary := make(map[string]struct{})
+	for _, words := range m.Words {
+		for word := range words {
+			vocabulary[word] = struct{}{}
+		}
+	}
+	m.vocabulary = len(vocabulary)
+}
+
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.TrimSpace(label))
+}
+
+// features returns the words of text, with words inside a negation window
+// prefixed by "not_".
+func features(text string) []string {
+	var result []string
+	negated := 0
+	for _, token := range tokenize(text) {
+		switch {
+		case token == "":
+			negated = 0
+		case negators[token] || strings.HasSuffix(token, "n't"):
+			negated = negationWindow
+		case negated > 0:
+			result = append(result, "not_"+token)
+			negated--
+		default:
+			result = append(result, token)
+		}
+	}
+	return result
+}
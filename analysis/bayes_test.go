@@ -0,0 +1,69 @@
+package analysis
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var trainingExamples = []Example{
+	{"Great service, I love it", "positive"},
+	{"Excellent quality and fast shipping", "positive"},
+	{"The staff were friendly and helpful", "positive"},
+	{"Really happy with this purchase", "positive"},
+	{"Not bad at all, works well", "positive"},
+	{"Terrible experience, never again", "negative"},
+	{"The product arrived broken", "negative"},
+	{"Awful support and slow replies", "negative"},
+	{"Not good, I want a refund", "negative"},
+	{"Worst purchase I have made", "negative"},
+	{"What time do you open?", "neutral"},
+	{"Where is your store located?", "neutral"},
+	{"Do you ship to Canada?", "neutral"},
+}
+
+func TestNaiveBayes(t *testing.T) {
+	model := NewNaiveBayes()
+	model.Train(trainingExamples)
+	assert.Equal(t, []string{"negative", "neutral", "positive"}, model.Classes())
+
+	testCases := []struct {
+		Text     string
+		Expected string
+	}{
+		{"I love the fast shipping", "positive"},
+		{"It arrived broken, awful", "negative"},
+		{"Where do you ship?", "neutral"},
+		{"Not good", "negative"},
+	}
+	for _, tc := range testCases {
+		result, err := model.Analyze(context.Background(), tc.Text)
+		require.NoError(t, err)
+		assert.Equal(t, tc.Expected, result.Prediction, "Unexpected sentiment for text '%s'", tc.Text)
+		assert.True(t, result.Probability > 1.0/3 && result.Probability <= 1)
+	}
+
+	scores := model.Predict("I love the fast shipping").Scores
+	require.Len(t, scores, 3)
+	assert.InDelta(t, 1, scores["negative"]+scores["neutral"]+scores["positive"], 1e-9)
+	assert.Greater(t, scores["positive"], scores["negative"])
+
+	path := filepath.Join(t.TempDir(), "model", "sentiment.json")
+	require.NoError(t, model.Save(path))
+	loaded, err := LoadNaiveBayes(path)
+	require.NoError(t, err)
+	assert.Equal(t, model.Predict("I love the fast shipping"), loaded.Predict("I love the fast shipping"))
+
+	_, err = NewNaiveBayes().Analyze(context.Background(), "text")
+	assert.Error(t, err)
+	_, err = LoadNaiveBayes(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestFeaturesMarkNegation(t *testing.T) {
+	assert.Equal(t, []string{"it", "is", "not_good", "not_at", "not_all", "fine"}, features("It is not good at all. Fine"))
+	assert.Equal(t, []string{"not_bad"}, features("isn't bad"))
+}
@@ -0,0 +1,164 @@
+package analysis
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// BatchAnalyzer is implemented by analyzers that score many texts in fewer
+// calls than one per text.
+type BatchAnalyzer interface {
+	AnalyzeBatch(ctx context.Context, texts []string) ([]PredictionResult, error)
+}
+
+// AnalyzeBatch scores texts with analyzer, in batches when it supports them
+// and one at a time otherwise. Results are in the order of texts.
+func AnalyzeBatch(ctx context.Context, analyzer Analyzer, texts []string) ([]PredictionResult, error) {
+	if batch, ok := analyzer.(BatchAnalyzer); ok {
+		return batch.AnalyzeBatch(ctx, texts)
+	}
+	results := make([]PredictionResult, len(texts))
+	for i, text := range texts {
+		result, err := analyzer.Analyze(ctx, text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze %q: %w", text, err)
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// NormalizeText folds case and whitespace so texts that differ only in those
+// share a cache entry.
+func NormalizeText(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// CacheStats reports how well a CachedAnalyzer is doing.
+type CacheStats struct {
+	Size   int    `json:"size"`
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// CachedAnalyzer remembers the results of Analyzer for the most recently
+// seen texts, so repeated comments such as spam are only scored once. Errors
+// and fallback results are not cached.
+type CachedAnalyzer struct {
+	Analyzer Analyzer
+
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	hits    uint64
+	misses  uint64
+}
+
+type cacheEntry struct {
+	key    string
+	result PredictionResult
+}
+
+// NewCachedAnalyzer caches up to size results of analyzer, dropping the
+// least recently used first.
+func NewCachedAnalyzer(analyzer Analyzer, size int) *CachedAnalyzer {
+	return &CachedAnalyzer{
+		Analyzer: analyzer,
+		size:     size,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (a *CachedAnalyzer) Analyze(ctx context.Context, text string) (PredictionResult, error) {
+	key := NormalizeText(text)
+	if result, ok := a.get(key); ok {
+		return result, nil
+	}
+	result, err := a.Analyzer.Analyze(ctx, text)
+	if err != nil {
+		return result, err
+	}
+	if !result.fallback {
+		a.add(key, result)
+	}
+	return result, nil
+}
+
+// AnalyzeBatch answers cached texts directly and scores the others, each
+// distinct text once, in a single batch.
+func (a *CachedAnalyzer) AnalyzeBatch(ctx context.Context, texts []string) ([]PredictionResult, error) {
+	results := make([]PredictionResult, len(texts))
+	missing := make(map[string][]int)
+	var keys, pending []string
+	for i, text := range texts {
+		key := NormalizeText(text)
+		if indexes, ok := missing[key]; ok {
+			missing[key] = append(indexes, i)
+			continue
+		}
+		if result, ok := a.get(key); ok {
+			results[i] = result
+			continue
+		}
+		missing[key] = []int{i}
+		keys = append(keys, key)
+		pending = append(pending, text)
+	}
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	scored, err := AnalyzeBatch(ctx, a.Analyzer, pending)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		if !scored[i].fallback {
+			a.add(key, scored[i])
+		}
+		for _, index := range missing[key] {
+			results[index] = scored[i]
+		}
+	}
+	return results, nil
+}
+
+func (a *CachedAnalyzer) Stats() CacheStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return CacheStats{Size: a.order.Len(), Hits: a.hits, Misses: a.misses}
+}
+
+func (a *CachedAnalyzer) get(key string) (PredictionResult, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	element, ok := a.entries[key]
+	if !ok {
+		a.misses++
+		return PredictionResult{}, false
+	}
+	a.hits++
+	a.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).result, true
+}
+
+func (a *CachedAnalyzer) add(key string, result PredictionResult) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if element, ok := a.entries[key]; ok {
+		element.Value.(*cacheEntry).result = result
+		a.order.MoveToFront(element)
+		return
+	}
+	a.entries[key] = a.order.PushFront(&cacheEntry{key: key, result: result})
+	for a.order.Len() > a.size {
+		oldest := a.order.Back()
+		a.order.Remove(oldest)
+		delete(a.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,85 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/qew21/fb-messenger/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeText(t *testing.T) {
+	assert.Equal(t, "buy now at example.com", NormalizeText("  BUY now\n\tat  Example.com "))
+}
+
+func TestCachedAnalyzer(t *testing.T) {
+	stub := &stubAnalyzer{result: PredictionResult{Prediction: Negative, Probability: 0.9}}
+	analyzer := NewCachedAnalyzer(stub, 2)
+	ctx := context.Background()
+
+	for _, text := range []string{"Buy now!", "buy  NOW!", "Buy now!"} {
+		result, err := analyzer.Analyze(ctx, text)
+		require.NoError(t, err)
+		assert.Equal(t, Negative, result.Prediction)
+	}
+	assert.Equal(t, 1, stub.calls)
+
+	analyzer.Analyze(ctx, "second")
+	analyzer.Analyze(ctx, "buy now!")
+	analyzer.Analyze(ctx, "third")
+	// "second" was the least recently used, so it was evicted.
+	analyzer.Analyze(ctx, "second")
+	assert.Equal(t, 4, stub.calls)
+	assert.Equal(t, CacheStats{Size: 2, Hits: 3, Misses: 4}, analyzer.Stats())
+}
+
+func TestCachedAnalyzerBatch(t *testing.T) {
+	stub := &stubAnalyzer{result: PredictionResult{Prediction: Positive, Probability: 0.7}}
+	analyzer := NewCachedAnalyzer(stub, 10)
+	ctx := context.Background()
+
+	_, err := analyzer.Analyze(ctx, "great")
+	require.NoError(t, err)
+	results, err := AnalyzeBatch(ctx, analyzer, []string{"Great", "spam spam", "SPAM  spam", "fine"})
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	for _, result := range results {
+		assert.Equal(t, Positive, result.Prediction)
+	}
+	// Only "spam spam" and "fine" were new.
+	assert.Equal(t, 3, stub.calls)
+}
+
+func TestCachedAnalyzerSkipsFallbackResults(t *testing.T) {
+	primary := &stubAnalyzer{err: errors.New("predictor down")}
+	fallback := &stubAnalyzer{result: PredictionResult{Prediction: Neutral, Probability: 1}}
+	analyzer := NewCachedAnalyzer(NewFallbackAnalyzer(primary, fallback, 0), 10)
+	ctx := context.Background()
+
+	_, err := analyzer.Analyze(ctx, "great")
+	require.NoError(t, err)
+	_, err = AnalyzeBatch(ctx, analyzer, []string{"fine", "ok"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, analyzer.Stats().Size)
+
+	// Once the predictor is back, its results are used and cached.
+	primary.err = nil
+	primary.result = PredictionResult{Prediction: Positive, Probability: 0.9}
+	for i := 0; i < 2; i++ {
+		result, err := analyzer.Analyze(ctx, "great")
+		require.NoError(t, err)
+		assert.Equal(t, Positive, result.Prediction)
+	}
+	assert.Equal(t, 3, primary.calls)
+	assert.Equal(t, 1, analyzer.Stats().Size)
+}
+
+func TestFromConfigCache(t *testing.T) {
+	analyzer, err := FromConfig(&config.AppConfig{SentimentAnalyzer: AnalyzerLexicon, SentimentCacheSize: 5})
+	require.NoError(t, err)
+	require.IsType(t, &CachedAnalyzer{}, analyzer)
+	assert.IsType(t, &LexiconAnalyzer{}, analyzer.(*CachedAnalyzer).Analyzer)
+}
@@ -0,0 +1,221 @@
+package analysis
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// LoadExamples reads labeled texts from a .jsonl file of {"text", "label"}
+// objects or a .csv file. A CSV header naming "text" and "label" columns is
+// used when present; otherwise the first two columns are text and label.
+func LoadExamples(path string) ([]Example, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open examples: %w", err)
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl":
+		return readJSONLExamples(file)
+	case ".csv":
+		return readCSVExamples(file)
+	default:
+		return nil, fmt.Errorf("unsupported example file %s, expected .csv or .jsonl", path)
+	}
+}
+
+func readJSONLExamples(r io.Reader) ([]Example, error) {
+	var examples []Example
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var example Example
+		if err := json.Unmarshal(scanner.Bytes(), &example); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		examples = append(examples, example)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read examples: %w", err)
+	}
+	return examples, nil
+}
+
+func readCSVExamples(r io.Reader) ([]Example, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read examples: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	textColumn, labelColumn := 0, 1
+	if hasColumns(records[0], "text", "label") {
+		for i, name := range records[0] {
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "text":
+				textColumn = i
+			case "label":
+				labelColumn = i
+			}
+		}
+		records = records[1:]
+	}
+
+	examples := make([]Example, 0, len(records))
+	for i, record := range records {
+		if len(record) <= textColumn || len(record) <= labelColumn {
+			return nil, fmt.Errorf("record %d has %d fields", i+1, len(record))
+		}
+		examples = append(examples, Example{Text: record[textColumn], Label: record[labelColumn]})
+	}
+	return examples, nil
+}
+
+func hasColumns(record []string, names ...string) bool {
+	found := 0
+	for _, field := range record {
+		for _, name := range names {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				found++
+			}
+		}
+	}
+	return found == len(names)
+}
+
+// SplitExamples shuffles examples with seed and holds out the given
+// fraction for evaluation.
+func SplitExamples(examples []Example, holdout float64, seed int64) (train []Example, test []Example) {
+	shuffled := append([]Example(nil), examples...)
+	rand.New(rand.NewSource(seed)).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	n := int(float64(len(shuffled)) * holdout)
+	return shuffled[n:], shuffled[:n]
+}
+
+type ClassMetrics struct {
+	Label     string  `json:"label"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	Support   int     `json:"support"`
+}
+
+// Evaluation measures an analyzer against labeled examples. Confusion is
+// indexed by the expected label, then the predicted label.
+type Evaluation struct {
+	Examples  int                       `json:"examples"`
+	Accuracy  float64                   `json:"accuracy"`
+	Classes   []ClassMetrics            `json:"classes"`
+	Confusion map[string]map[string]int `json:"confusion"`
+}
+
+func Evaluate(ctx context.Context, analyzer Analyzer, examples []Example) (Evaluation, error) {
+	evaluation := Evaluation{Confusion: make(map[string]map[string]int)}
+	labels := make(map[string]bool)
+	var expectedLabels, texts []string
+	for _, example := range examples {
+		if expected := normalizeLabel(example.Label); expected != "" {
+			expectedLabels = append(expectedLabels, expected)
+			texts = append(texts, example.Text)
+		}
+	}
+	results, err := AnalyzeBatch(ctx, analyzer, texts)
+	if err != nil {
+		return evaluation, err
+	}
+
+	correct := 0
+	for i, expected := range expectedLabels {
+		predicted := normalizeLabel(results[i].Prediction)
+
+		if evaluation.Confusion[expected] == nil {
+			evaluation.Confusion[expected] = make(map[string]int)
+		}
+		evaluation.Confusion[expected][predicted]++
+		labels[expected], labels[predicted] = true, true
+		evaluation.Examples++
+		if predicted == expected {
+			correct++
+		}
+	}
+	if evaluation.Examples == 0 {
+		return evaluation, nil
+	}
+	evaluation.Accuracy = float64(correct) / float64(evaluation.Examples)
+
+	for _, label := range sortedKeys(labels) {
+		metrics := ClassMetrics{Label: label}
+		predicted := 0
+		for _, row := range evaluation.Confusion {
+			predicted += row[label]
+		}
+		truePositives := evaluation.Confusion[label][label]
+		for _, count := range evaluation.Confusion[label] {
+			metrics.Support += count
+		}
+		if predicted > 0 {
+			metrics.Precision = float64(truePositives) / float64(predicted)
+		}
+		if metrics.Support > 0 {
+			metrics.Recall = float64(truePositives) / float64(metrics.Support)
+		}
+		evaluation.Classes = append(evaluation.Classes, metrics)
+	}
+	return evaluation, nil
+}
+
+// Report writes the evaluation as plain-text tables.
+func (e Evaluation) Report(w io.Writer) {
+	fmt.Fprintf(w, "examples: %d\naccuracy: %.3f\n\n", e.Examples, e.Accuracy)
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "label\tprecision\trecall\tsupport")
+	for _, class := range e.Classes {
+		fmt.Fprintf(table, "%s\t%.3f\t%.3f\t%d\n", class.Label, class.Precision, class.Recall, class.Support)
+	}
+	table.Flush()
+
+	fmt.Fprintln(w, "\nconfusion (rows expected, columns predicted):")
+	table = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	header := []string{""}
+	for _, class := range e.Classes {
+		header = append(header, class.Label)
+	}
+	fmt.Fprintln(table, strings.Join(header, "\t"))
+	for _, expected := range e.Classes {
+		row := []string{expected.Label}
+		for _, predicted := range e.Classes {
+			row = append(row, fmt.Sprint(e.Confusion[expected.Label][predicted.Label]))
+		}
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	table.Flush()
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
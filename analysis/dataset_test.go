@@ -0,0 +1,69 @@
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadExamples(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"header.csv":     "id,label,text\n1,positive,\"Great, really great\"\n2,negative,Bad\n",
+		"plain.csv":      "\"Great, really great\",positive\nBad,negative\n",
+		"examples.jsonl": "{\"text\": \"Great, really great\", \"label\": \"positive\"}\n\n{\"text\": \"Bad\", \"label\": \"negative\"}\n",
+	}
+	expected := []Example{{"Great, really great", "positive"}, {"Bad", "negative"}}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		examples, err := LoadExamples(path)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, examples, name)
+	}
+
+	_, err := LoadExamples(filepath.Join(dir, "examples.txt"))
+	assert.Error(t, err)
+}
+
+func TestSplitExamples(t *testing.T) {
+	train, test := SplitExamples(trainingExamples, 0.25, 1)
+	assert.Len(t, test, 3)
+	assert.Len(t, train, len(trainingExamples)-3)
+
+	again, _ := SplitExamples(trainingExamples, 0.25, 1)
+	assert.Equal(t, train, again)
+}
+
+func TestEvaluate(t *testing.T) {
+	examples := []Example{
+		{"This film is great!", "positive"},
+		{"This film is terrible!", "negative"},
+		{"This film is not terrible!", "positive"},
+		{"Where can I find this film?", "negative"},
+	}
+	evaluation, err := Evaluate(context.Background(), NewLexiconAnalyzer(), examples)
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, evaluation.Examples)
+	assert.Equal(t, 0.75, evaluation.Accuracy)
+	assert.Equal(t, map[string]map[string]int{
+		"positive": {"positive": 2},
+		"negative": {"negative": 1, "neutral": 1},
+	}, evaluation.Confusion)
+	assert.Equal(t, []ClassMetrics{
+		{Label: "negative", Precision: 1, Recall: 0.5, Support: 2},
+		{Label: "neutral", Precision: 0, Recall: 0, Support: 0},
+		{Label: "positive", Precision: 1, Recall: 1, Support: 2},
+	}, evaluation.Classes)
+
+	var report bytes.Buffer
+	evaluation.Report(&report)
+	assert.Contains(t, report.String(), "accuracy: 0.750")
+	assert.Contains(t, report.String(), "negative  1.000      0.500   2")
+}
@@ -0,0 +1,172 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Emotions and aspects Tag recognizes.
+const (
+	EmotionAnger       = "anger"
+	EmotionJoy         = "joy"
+	EmotionFrustration = "frustration"
+	EmotionConfusion   = "confusion"
+
+	AspectShipping = "shipping"
+	AspectPrice    = "price"
+	AspectQuality  = "quality"
+	AspectService  = "service"
+)
+
+// Label is a category found in a text, scored from 0 to 1.
+type Label struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// keywords lists the English words and Chinese phrases that signal a
+// category. Negated emotion keywords do not count, so "not happy" and "不满意"
+// are not joy; aspects count either way, "not expensive" is about price too.
+// Single Chinese characters and question words are too ambiguous to be
+// keywords.
+type keywords struct {
+	english []string
+	chinese []string
+}
+
+var emotionKeywords = map[string]keywords{
+	EmotionAnger: {
+		english: []string{"angry", "furious", "outraged", "hate", "scam", "ridiculous", "unacceptable", "worst", "mad", "disgusting"},
+		chinese: []string{"生气", "愤怒", "气死", "骗子", "垃圾", "过分", "恶心"},
+	},
+	EmotionJoy: {
+		english: []string{"happy", "love", "loved", "glad", "great", "amazing", "awesome", "excellent", "wonderful", "thanks", "delighted", "enjoyed"},
+		chinese: []string{"开心", "喜欢", "满意", "太棒", "谢谢", "高兴", "感谢"},
+	},
+	EmotionFrustration: {
+		english: []string{"still", "again", "waiting", "waited", "nobody", "disappointed", "annoying", "annoyed", "useless", "tired", "frustrated", "frustrating", "ignored"},
+		chinese: []string{"还没", "一直", "失望", "等了", "无语", "烦人", "烦死", "没人"},
+	},
+	EmotionConfusion: {
+		english: []string{"confused", "confusing", "unclear", "not_understand", "?"},
+		chinese: []string{"不懂", "不明白", "搞不清", "什么意思", "怎么回事"},
+	},
+}
+
+var aspectKeywords = map[string]keywords{
+	AspectShipping: {
+		english: []string{"shipping", "ship", "shipped", "delivery", "deliver", "delivered", "courier", "package", "parcel", "arrived", "arrive", "tracking", "box"},
+		chinese: []string{"快递", "物流", "发货", "送货", "配送", "包裹", "运费", "到货", "盒子"},
+	},
+	AspectPrice: {
+		english: []string{"price", "prices", "expensive", "cheap", "cost", "costs", "overpriced", "refund", "discount", "money", "paid", "charged"},
+		chinese: []string{"价格", "太贵", "很贵", "好贵", "便宜", "价钱", "退款", "优惠", "性价比", "收费"},
+	},
+	AspectQuality: {
+		english: []string{"quality", "broken", "damaged", "defective", "material", "durable", "fake", "flimsy", "sturdy", "works", "stopped"},
+		chinese: []string{"质量", "破损", "坏了", "损坏", "做工", "材质", "假货", "耐用"},
+	},
+	AspectService: {
+		english: []string{"service", "support", "staff", "customer", "reply", "replies", "response", "rude", "agent", "helpful", "friendly"},
+		chinese: []string{"客服", "服务", "态度", "回复", "售后"},
+	},
+}
+
+// emotionSentiments is the sentiment each emotion goes with, used to rank
+// equally likely emotions.
+var emotionSentiments = map[string]string{
+	EmotionAnger:       Negative,
+	EmotionJoy:         Positive,
+	EmotionFrustration: Negative,
+	EmotionConfusion:   Neutral,
+}
+
+// Tag adds the emotions and aspects found in text to result, unless the
+// analyzer already provided them. Of equally likely emotions, those that go
+// with the predicted sentiment come first.
+func Tag(result PredictionResult, text string) PredictionResult {
+	if result.Emotions == nil {
+		result.Emotions = match(emotionKeywords, text, true, func(name string) bool {
+			return emotionSentiments[name] == result.Prediction
+		})
+	}
+	if result.Aspects == nil {
+		result.Aspects = match(aspectKeywords, text, false, nil)
+	}
+	return result
+}
+
+// match scores each category by its keyword hits, most likely first. Ties go
+// to the categories preferred, then by name.
+func match(categories map[string]keywords, text string, negation bool, preferred func(name string) bool) []Label {
+	words := make(map[string]int)
+	for _, feature := range features(text) {
+		if !negation {
+			feature = strings.TrimPrefix(feature, "not_")
+		}
+		words[feature]++
+	}
+	words["?"] = strings.Count(text, "?") + strings.Count(text, "？")
+
+	var labels []Label
+	for name, category := range categories {
+		hits := 0
+		for _, word := range category.english {
+			hits += words[word]
+		}
+		for _, phrase := range category.chinese {
+			hits += countPhrase(text, phrase, negation)
+		}
+		if hits > 0 {
+			labels = append(labels, Label{Name: name, Score: 1 - math.Exp(-float64(hits))})
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].Score != labels[j].Score {
+			return labels[i].Score > labels[j].Score
+		}
+		if preferred != nil && preferred(labels[i].Name) != preferred(labels[j].Name) {
+			return preferred(labels[i].Name)
+		}
+		return labels[i].Name < labels[j].Name
+	})
+	return labels
+}
+
+// countPhrase counts the occurrences of a Chinese phrase in text, leaving
+// out negated ones when negation applies.
+func countPhrase(text string, phrase string, negation bool) int {
+	hits := 0
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], phrase)
+		if i < 0 {
+			return hits
+		}
+		start := offset + i
+		if !negation || !chineseNegated(text[:start]) {
+			hits++
+		}
+		offset = start + len(phrase)
+	}
+}
+
+// chineseNegated reports whether the text before a phrase ends with a
+// negator, possibly followed by intensifiers as in "不太" or "不是很".
+func chineseNegated(before string) bool {
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for intensifier := range chineseIntensifiers {
+			if strings.HasSuffix(before, intensifier) {
+				before = strings.TrimSuffix(before, intensifier)
+				trimmed = true
+			}
+		}
+	}
+	for negator := range chineseNegators {
+		if strings.HasSuffix(before, negator) {
+			return true
+		}
+	}
+	return false
+}
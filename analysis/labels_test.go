@@ -0,0 +1,61 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func labelNames(labels []Label) []string {
+	var names []string
+	for _, label := range labels {
+		names = append(names, label.Name)
+	}
+	return names
+}
+
+func TestTag(t *testing.T) {
+	testCases := []struct {
+		Text     string
+		Emotions []string
+		Aspects  []string
+	}{
+		{"I'm still waiting for my package, the tracking hasn't moved", []string{EmotionFrustration}, []string{AspectShipping}},
+		{"This is a scam, the price is ridiculous and support was rude", []string{EmotionAnger}, []string{AspectService, AspectPrice}},
+		{"How do I get a refund?", []string{EmotionConfusion}, []string{AspectPrice}},
+		{"Love it, great quality!", []string{EmotionJoy}, []string{AspectQuality}},
+		{"I'm not happy", nil, nil},
+		{"快递一直没到，客服也不回复，气死了", []string{EmotionAnger, EmotionFrustration}, []string{AspectService, AspectShipping}},
+		{"很不满意", nil, nil},
+		{"我不喜欢这个", nil, nil},
+		{"不是很满意，太贵了", nil, []string{AspectPrice}},
+		{"又快又好", nil, nil},
+		{"I don't understand the price", []string{EmotionConfusion}, []string{AspectPrice}},
+		{"I understand, thanks", []string{EmotionJoy}, nil},
+	}
+	for _, tc := range testCases {
+		result := Tag(PredictionResult{Prediction: Negative}, tc.Text)
+		assert.Equal(t, tc.Emotions, labelNames(result.Emotions), "Unexpected emotions for text '%s'", tc.Text)
+		assert.Equal(t, tc.Aspects, labelNames(result.Aspects), "Unexpected aspects for text '%s'", tc.Text)
+	}
+
+	// Question words alone are not confusion.
+	praise := Tag(PredictionResult{Prediction: Positive}, "What a great product!")
+	assert.Equal(t, []string{EmotionJoy}, labelNames(praise.Emotions))
+
+	// Equally likely emotions are ranked by the predicted sentiment.
+	mixed := "Thanks, but I am still here"
+	assert.Equal(t, EmotionFrustration, Tag(PredictionResult{Prediction: Negative}, mixed).Emotion())
+	assert.Equal(t, EmotionJoy, Tag(PredictionResult{Prediction: Positive}, mixed).Emotion())
+
+	scored := Tag(PredictionResult{}, "The box arrived broken and the delivery was late")
+	require.Len(t, scored.Aspects, 2)
+	assert.Equal(t, AspectShipping, scored.Aspect())
+	assert.Greater(t, scored.Aspects[0].Score, scored.Aspects[1].Score)
+
+	// Labels from the analyzer are kept.
+	provided := PredictionResult{Aspects: []Label{{Name: AspectPrice, Score: 0.9}}, Emotions: []Label{}}
+	assert.Equal(t, provided, Tag(provided, "The delivery was late"))
+	assert.Equal(t, "", provided.Emotion())
+}
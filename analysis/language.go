@@ -0,0 +1,95 @@
+package analysis
+
+import (
+	"context"
+	"unicode"
+)
+
+// Languages DetectLanguage can tell apart.
+const (
+	LanguageEnglish = "en"
+	LanguageChinese = "zh"
+)
+
+// DetectLanguage returns LanguageChinese for text mostly written in Han
+// characters, LanguageEnglish for text in Latin letters and "" when there is
+// nothing to go by, such as emoji only. A Han character carries about as
+// much as a short English word, so a few of them outweigh Latin brand names.
+func DetectLanguage(text string) string {
+	han, latin := 0, 0
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	switch {
+	case han > 0 && han*4 >= latin:
+		return LanguageChinese
+	case latin > 0:
+		return LanguageEnglish
+	default:
+		return ""
+	}
+}
+
+// LanguageRouter detects the language of each text and passes it to the
+// analyzer configured for that language, or to Default.
+type LanguageRouter struct {
+	Analyzers map[string]Analyzer
+	Default   Analyzer
+}
+
+func (r *LanguageRouter) Analyze(ctx context.Context, text string) (PredictionResult, error) {
+	language := DetectLanguage(text)
+	result, err := r.analyzerFor(language).Analyze(ctx, text)
+	if err != nil {
+		return result, err
+	}
+	if result.Language == "" {
+		result.Language = language
+	}
+	return result, nil
+}
+
+// AnalyzeBatch passes each language's texts to its analyzer in one batch.
+func (r *LanguageRouter) AnalyzeBatch(ctx context.Context, texts []string) ([]PredictionResult, error) {
+	groups := make(map[string][]int)
+	var languages []string
+	for i, text := range texts {
+		language := DetectLanguage(text)
+		if _, ok := groups[language]; !ok {
+			languages = append(languages, language)
+		}
+		groups[language] = append(groups[language], i)
+	}
+
+	results := make([]PredictionResult, len(texts))
+	for _, language := range languages {
+		indexes := groups[language]
+		group := make([]string, len(indexes))
+		for i, index := range indexes {
+			group[i] = texts[index]
+		}
+		scored, err := AnalyzeBatch(ctx, r.analyzerFor(language), group)
+		if err != nil {
+			return nil, err
+		}
+		for i, index := range indexes {
+			if scored[i].Language == "" {
+				scored[i].Language = language
+			}
+			results[index] = scored[i]
+		}
+	}
+	return results, nil
+}
+
+func (r *LanguageRouter) analyzerFor(language string) Analyzer {
+	if analyzer, ok := r.Analyzers[language]; ok {
+		return analyzer
+	}
+	return r.Default
+}
@@ -0,0 +1,98 @@
+package analysis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/qew21/fb-messenger/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	testCases := []struct {
+		Text     string
+		Expected string
+	}{
+		{"This film is great!", LanguageEnglish},
+		{"这部电影太棒了！", LanguageChinese},
+		{"我的iPhone订单还没到", LanguageChinese},
+		{"Is the 订单 shipped yet? I ordered it last week", LanguageEnglish},
+		{"👍👍", ""},
+		{"", ""},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.Expected, DetectLanguage(tc.Text), "Unexpected language for text '%s'", tc.Text)
+	}
+}
+
+func TestChineseLexiconAnalyzer(t *testing.T) {
+	testCases := []struct {
+		Text     string
+		Expected string
+	}{
+		{"这部电影太糟糕了！", Negative},
+		{"这部电影很好看，非常喜欢", Positive},
+		{"质量不错", Positive},
+		{"一点也不好，我很失望", Negative},
+		{"没有问题，谢谢", Positive},
+		{"请问你们几点开门？", Neutral},
+	}
+
+	analyzer := NewChineseLexiconAnalyzer()
+	for _, tc := range testCases {
+		result, err := analyzer.Analyze(context.Background(), tc.Text)
+		require.NoError(t, err)
+		assert.Equal(t, tc.Expected, result.Prediction, "Unexpected sentiment for text '%s'", tc.Text)
+	}
+}
+
+func TestLanguageRouter(t *testing.T) {
+	english := &stubAnalyzer{result: PredictionResult{Prediction: Positive, Probability: 0.9}}
+	chinese := &stubAnalyzer{result: PredictionResult{Prediction: Negative, Probability: 0.8}}
+	router := &LanguageRouter{Analyzers: map[string]Analyzer{LanguageChinese: chinese}, Default: english}
+
+	result, err := router.Analyze(context.Background(), "太差了")
+	require.NoError(t, err)
+	assert.Equal(t, PredictionResult{Prediction: Negative, Probability: 0.8, Language: LanguageChinese}, result)
+
+	result, err = router.Analyze(context.Background(), "great")
+	require.NoError(t, err)
+	assert.Equal(t, LanguageEnglish, result.Language)
+	assert.Equal(t, 1, english.calls)
+}
+
+func TestLanguageRouterBatch(t *testing.T) {
+	chinese := &stubAnalyzer{result: PredictionResult{Prediction: Negative, Probability: 0.9}}
+	router := &LanguageRouter{Analyzers: map[string]Analyzer{LanguageChinese: chinese}, Default: NewLexiconAnalyzer()}
+
+	results, err := AnalyzeBatch(context.Background(), router, []string{"太差了", "great", "不好"})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, Negative, results[0].Prediction)
+	assert.Equal(t, LanguageChinese, results[0].Language)
+	assert.Equal(t, Positive, results[1].Prediction)
+	assert.Equal(t, LanguageEnglish, results[1].Language)
+	assert.Equal(t, LanguageChinese, results[2].Language)
+	assert.Equal(t, 2, chinese.calls)
+}
+
+func TestFromConfigLanguages(t *testing.T) {
+	analyzer, err := FromConfig(&config.AppConfig{
+		SentimentAnalyzer:  AnalyzerLexicon,
+		SentimentLanguages: map[string]config.LanguageAnalyzer{LanguageChinese: {}},
+	})
+	require.NoError(t, err)
+	router, ok := analyzer.(*LanguageRouter)
+	require.True(t, ok)
+	assert.Equal(t, NewLexiconAnalyzer(), router.Default)
+	assert.Equal(t, NewChineseLexiconAnalyzer(), router.Analyzers[LanguageChinese])
+
+	result, err := analyzer.Analyze(context.Background(), "非常满意")
+	require.NoError(t, err)
+	assert.Equal(t, Positive, result.Prediction)
+	assert.Equal(t, LanguageChinese, result.Language)
+
+	_, err = FromConfig(&config.AppConfig{SentimentLanguages: map[string]config.LanguageAnalyzer{LanguageChinese: {Analyzer: "magic"}}})
+	assert.Error(t, err)
+}
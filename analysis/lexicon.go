@@ -0,0 +1,213 @@
+package analysis
+
+import (
+	"context"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// negationFactor scales the polarity of a negated word; "not terrible" is
+// milder than "great".
+const negationFactor = -0.75
+
+// negationWindow is how many words after a negator it applies to.
+const negationWindow = 3
+
+// DefaultLexicon scores common English review words from -3 to 3.
+var DefaultLexicon = map[string]float64{
+	"amazing": 3, "awesome": 3, "best": 3, "excellent": 3, "fantastic": 3, "love": 3, "loved": 3,
+	"perfect": 3, "superb": 3, "wonderful": 3,
+	"beautiful": 2, "enjoy": 2, "enjoyed": 2, "glad": 2, "good": 2, "great": 2, "happy": 2,
+	"helpful": 2, "impressed": 2, "nice": 2, "pleased": 2, "recommend": 2, "satisfied": 2,
+	"thank": 2, "thanks": 2, "fast": 1, "easy": 1, "fine": 1, "friendly": 1, "like": 1,
+	"liked": 1, "quick": 1, "worth": 1,
+
+	"awful": -3, "disgusting": -3, "hate": -3, "hated": -3, "horrible": -3, "scam": -3,
+	"terrible": -3, "worst": -3, "useless": -3,
+	"angry": -2, "bad": -2, "broken": -2, "damaged": -2, "defective": -2, "disappointed": -2,
+	"disappointing": -2, "fake": -2, "poor": -2, "rude": -2, "unhappy": -2, "waste": -2,
+	"wrong": -2, "annoyed": -2, "sucks": -2, "failed": -2,
+	"boring": -1, "delay": -1, "delayed": -1, "dirty": -1, "expensive": -1, "issue": -1,
+	"late": -1, "lost": -1, "missing": -1, "problem": -1, "sad": -1, "slow": -1,
+}
+
+// ChineseLexicon scores common Chinese review words from -3 to 3.
+var ChineseLexicon = map[string]float64{
+	"完美": 3, "超赞": 3, "太棒了": 3, "喜欢": 3, "最好": 3, "优秀": 3, "爱": 3,
+	"满意": 2, "不错": 2, "好用": 2, "开心": 2, "推荐": 2, "谢谢": 2, "感谢": 2, "值得": 2,
+	"好": 2, "棒": 2, "赞": 2, "快": 1, "方便": 1, "热情": 1, "实惠": 1,
+
+	"垃圾": -3, "骗子": -3, "最差": -3, "糟糕": -3, "讨厌": -3, "恶心": -3,
+	"差": -2, "坏": -2, "失望": -2, "生气": -2, "破损": -2, "烂": -2, "假货": -2, "退款": -2,
+	"难用": -2, "问题": -1, "慢": -1, "贵": -1, "延迟": -1, "丢失": -1, "投诉": -1,
+}
+
+var chineseNegators = map[string]bool{
+	"不": true, "没": true, "没有": true, "别": true, "未": true, "无": true, "非": true, "不是": true,
+}
+
+var chineseIntensifiers = map[string]float64{
+	"很": 1.5, "非常": 2, "太": 1.5, "特别": 1.5, "真": 1.3, "超级": 2, "有点": 0.5,
+}
+
+var negators = map[string]bool{
+	"not": true, "no": true, "never": true, "nothing": true, "neither": true, "nor": true,
+	"without": true, "hardly": true, "cannot": true,
+}
+
+var intensifiers = map[string]float64{
+	"very": 1.5, "really": 1.5, "so": 1.3, "extremely": 2, "super": 1.5, "absolutely": 1.5,
+	"slightly": 0.5, "somewhat": 0.5, "barely": 0.5,
+}
+
+// LexiconAnalyzer scores text offline by summing word polarities, flipping
+// words that follow a negation such as "not" or "isn't".
+type LexiconAnalyzer struct {
+	Lexicon      map[string]float64
+	Negators     map[string]bool
+	Intensifiers map[string]float64
+	// Segment splits text written without spaces, such as Chinese, into
+	// the longest known words.
+	Segment bool
+}
+
+func NewLexiconAnalyzer() *LexiconAnalyzer {
+	return &LexiconAnalyzer{Lexicon: DefaultLexicon, Negators: negators, Intensifiers: intensifiers}
+}
+
+func NewChineseLexiconAnalyzer() *LexiconAnalyzer {
+	return &LexiconAnalyzer{Lexicon: ChineseLexicon, Negators: chineseNegators, Intensifiers: chineseIntensifiers, Segment: true}
+}
+
+func (a *LexiconAnalyzer) Analyze(ctx context.Context, text string) (PredictionResult, error) {
+	return a.score(text), nil
+}
+
+func (a *LexiconAnalyzer) score(text string) PredictionResult {
+	tokens := tokenize(text)
+	if a.Segment {
+		tokens = a.segment(tokens)
+	}
+
+	score := 0.0
+	negated := 0
+	boost := 1.0
+	for _, token := range tokens {
+		if token == "" {
+			// Clause boundary.
+			negated = 0
+			boost = 1
+			continue
+		}
+		if a.Negators[token] || strings.HasSuffix(token, "n't") {
+			negated = negationWindow
+			continue
+		}
+		if factor, ok := a.Intensifiers[token]; ok {
+			boost *= factor
+			continue
+		}
+		if token == "but" {
+			// What follows "but" usually carries the opinion.
+			score *= 0.5
+			continue
+		}
+
+		polarity := a.Lexicon[token]
+		if negated > 0 {
+			polarity *= negationFactor
+			negated--
+		}
+		if polarity != 0 {
+			score += polarity * boost
+			boost = 1
+		}
+	}
+
+	return resultForScore(score)
+}
+
+// resultForScore maps a polarity score to a label with a probability that
+// grows with the size of the score.
+func resultForScore(score float64) PredictionResult {
+	magnitude := math.Abs(score)
+	if magnitude < 0.5 {
+		return PredictionResult{Prediction: Neutral, Probability: 1 - magnitude}
+	}
+	probability := 1 / (1 + math.Exp(-magnitude))
+	if score > 0 {
+		return PredictionResult{Prediction: Positive, Probability: probability}
+	}
+	return PredictionResult{Prediction: Negative, Probability: probability}
+}
+
+// maxWordLength is the longest word, in runes, segment looks for.
+const maxWordLength = 4
+
+// segment joins single Han characters into the longest words known to the
+// analyzer, leaving unknown characters on their own.
+func (a *LexiconAnalyzer) segment(tokens []string) []string {
+	known := func(word string) bool {
+		_, scored := a.Lexicon[word]
+		_, intensifier := a.Intensifiers[word]
+		return scored || intensifier || a.Negators[word]
+	}
+
+	var words []string
+	for i := 0; i < len(tokens); {
+		length := 1
+		if isHan(tokens[i]) {
+			word := tokens[i]
+			for n := 2; n <= maxWordLength && i+n <= len(tokens) && isHan(tokens[i+n-1]); n++ {
+				word += tokens[i+n-1]
+				if known(word) {
+					length = n
+				}
+			}
+		}
+		words = append(words, strings.Join(tokens[i:i+length], ""))
+		i += length
+	}
+	return words
+}
+
+func isHan(token string) bool {
+	for _, r := range token {
+		return unicode.Is(unicode.Han, r)
+	}
+	return false
+}
+
+// tokenize lowercases text and splits it into words, with an empty token
+// for each clause boundary. Han characters are tokens of their own, since
+// Chinese is written without spaces.
+func tokenize(text string) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\'' || r == '’':
+			if r == '’' {
+				r = '\''
+			}
+			word.WriteRune(r)
+		case strings.ContainsRune(".,;:!?。，；：！？、", r):
+			flush()
+			tokens = append(tokens, "")
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
@@ -0,0 +1,40 @@
+package analysis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLexiconAnalyzer(t *testing.T) {
+	testCases := []struct {
+		Text     string
+		Expected string
+	}{
+		{"This film is terrible!", Negative},
+		{"This film is great!", Positive},
+		{"This film is not terrible!", Positive},
+		{"This film is not great!", Negative},
+		{"Where can I find this film?", Neutral},
+		{"The delivery wasn't fast and the box was damaged.", Negative},
+		{"I don't hate it, it's really good", Positive},
+		{"Not good. But the support was excellent!", Positive},
+	}
+
+	analyzer := NewLexiconAnalyzer()
+	for _, tc := range testCases {
+		result, err := analyzer.Analyze(context.Background(), tc.Text)
+		require.NoError(t, err)
+		assert.Equal(t, tc.Expected, result.Prediction, "Unexpected sentiment for text '%s'", tc.Text)
+		assert.True(t, result.Probability >= 0.5 && result.Probability <= 1, "Probability %f out of range for '%s'", result.Probability, tc.Text)
+	}
+}
+
+func TestLexiconAnalyzerConfidence(t *testing.T) {
+	analyzer := NewLexiconAnalyzer()
+	strong, _ := analyzer.Analyze(context.Background(), "Absolutely amazing, I love it")
+	weak, _ := analyzer.Analyze(context.Background(), "It was fine")
+	assert.Greater(t, strong.Probability, weak.Probability)
+}
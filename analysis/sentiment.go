@@ -2,51 +2,227 @@ package analysis
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	Positive = "positive"
+	Negative = "negative"
+	Neutral  = "neutral"
 )
 
+// PredictionResult is the sentiment of a text. Scores, when the analyzer
+// provides them, hold the probability of every sentiment; Emotions and
+// Aspects list what the text expresses and is about, most likely first.
 type PredictionResult struct {
-	Prediction  string  `json:"prediction"`
-	Probability float64 `json:"probability"`
+	Prediction  string             `json:"prediction"`
+	Probability float64            `json:"probability"`
+	Scores      map[string]float64 `json:"scores,omitempty"`
+	Language    string             `json:"language,omitempty"`
+	Emotions    []Label            `json:"emotions,omitempty"`
+	Aspects     []Label            `json:"aspects,omitempty"`
+
+	// fallback is set on results that stand in for a failed analyzer.
+	fallback bool
+}
+
+// Confident returns the result, as neutral when its probability is below
+// threshold.
+func (r PredictionResult) Confident(threshold float64) PredictionResult {
+	if r.Probability < threshold {
+		r.Prediction = Neutral
+	}
+	return r
+}
+
+// Aspect returns the most likely aspect, or "".
+func (r PredictionResult) Aspect() string {
+	if len(r.Aspects) == 0 {
+		return ""
+	}
+	return r.Aspects[0].Name
+}
+
+// Emotion returns the most likely emotion, or "".
+func (r PredictionResult) Emotion() string {
+	if len(r.Emotions) == 0 {
+		return ""
+	}
+	return r.Emotions[0].Name
 }
 
 func Sentiment(url string, text string) (string, error) {
-	requestData := map[string]string{"text": text}
-	jsonPayload, err := json.Marshal(requestData)
+	result, err := Predict(url, text)
+	return result.Prediction, err
+}
+
+// Predict asks the predictor at url for the sentiment of text.
+func Predict(url string, text string) (PredictionResult, error) {
+	return NewHTTPAnalyzer(url, 0).Analyze(context.Background(), text)
+}
+
+// predictClient is shared by HTTP analyzers so connections to the predictor
+// are kept alive between requests instead of being opened for every text.
+var predictClient = newPredictClient()
+
+func newPredictClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 32
+	return &http.Client{Transport: transport}
+}
+
+// DefaultBatchSize is how many texts go into one batch request, or how many
+// single requests run at once when the predictor has no batch endpoint.
+const DefaultBatchSize = 32
+
+// HTTPAnalyzer asks an external predictor service, which answers a POST of
+// {"text": ...} with a PredictionResult. A predictor with a BatchURL answers
+// a POST of {"texts": [...]} with a list of results in the same order.
+type HTTPAnalyzer struct {
+	URL       string
+	BatchURL  string
+	BatchSize int
+	// Timeout bounds each request; zero waits as long as the context allows.
+	Timeout time.Duration
+	Client  *http.Client
+}
+
+// NewHTTPAnalyzer returns an analyzer for the predictor at url. A zero
+// timeout waits for as long as the context allows.
+func NewHTTPAnalyzer(url string, timeout time.Duration) *HTTPAnalyzer {
+	return &HTTPAnalyzer{URL: url, BatchSize: DefaultBatchSize, Timeout: timeout, Client: predictClient}
+}
+
+// PredictorError is a non-200 answer from the predictor.
+type PredictorError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *PredictorError) Error() string {
+	return fmt.Sprintf("server returned status code %d with body: %s", e.StatusCode, e.Body)
+}
+
+func (a *HTTPAnalyzer) Analyze(ctx context.Context, text string) (PredictionResult, error) {
+	var response PredictionResult
+	if err := a.post(ctx, a.URL, map[string]string{"text": text}, &response); err != nil {
+		return PredictionResult{}, err
+	}
+	return response, nil
+}
+
+// AnalyzeBatch posts texts to BatchURL, BatchSize at a time. Without a batch
+// endpoint, or when the predictor does not have one after all, texts are sent
+// one per request with BatchSize requests in flight.
+func (a *HTTPAnalyzer) AnalyzeBatch(ctx context.Context, texts []string) ([]PredictionResult, error) {
+	size := a.BatchSize
+	if size < 1 {
+		size = DefaultBatchSize
+	}
+	results := make([]PredictionResult, len(texts))
+	batches := a.BatchURL != ""
+	for start := 0; start < len(texts); start += size {
+		end := start + size
+		if end > len(texts) {
+			end = len(texts)
+		}
+		if batches {
+			err := a.analyzeChunk(ctx, texts[start:end], results[start:end])
+			var predictorErr *PredictorError
+			if errors.As(err, &predictorErr) && (predictorErr.StatusCode == http.StatusNotFound || predictorErr.StatusCode == http.StatusMethodNotAllowed) {
+				log.Warn().Err(err).Str("url", a.BatchURL).Msg("Predictor has no batch endpoint, sending texts one at a time")
+				batches = false
+			} else if err != nil {
+				return nil, err
+			} else {
+				continue
+			}
+		}
+		if err := a.analyzeEach(ctx, texts[start:end], results[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func (a *HTTPAnalyzer) analyzeChunk(ctx context.Context, texts []string, results []PredictionResult) error {
+	var response []PredictionResult
+	if err := a.post(ctx, a.BatchURL, map[string][]string{"texts": texts}, &response); err != nil {
+		return err
+	}
+	if len(response) != len(texts) {
+		return fmt.Errorf("predictor returned %d results for %d texts", len(response), len(texts))
+	}
+	copy(results, response)
+	return nil
+}
+
+func (a *HTTPAnalyzer) analyzeEach(ctx context.Context, texts []string, results []PredictionResult) error {
+	errs := make([]error, len(texts))
+	var wg sync.WaitGroup
+	for i := range texts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = a.Analyze(ctx, texts[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *HTTPAnalyzer) post(ctx context.Context, url string, request interface{}, response interface{}) error {
+	jsonPayload, err := json.Marshal(request)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request data: %w", err)
+		return fmt.Errorf("failed to marshal request data: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonPayload))
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonPayload))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
+	client := a.Client
+	if client == nil {
+		client = predictClient
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+		return fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("server returned status code %d with body: %s", resp.StatusCode, body)
+		return &PredictorError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
-	var response PredictionResult
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	if err := json.Unmarshal(body, response); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
 	}
-
-	return response.Prediction, nil
+	return nil
 }
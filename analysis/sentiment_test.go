@@ -30,3 +30,10 @@ func TestSentimentAnalysis(t *testing.T) {
 		assert.Equal(t, tc.Expected, sentiment, "Unexpected sentiment for text '%s'", tc.Text)
 	}
 }
+
+func TestPredictionResultConfident(t *testing.T) {
+	result := PredictionResult{Prediction: Positive, Probability: 0.7}
+	assert.Equal(t, result, result.Confident(0.6))
+	assert.Equal(t, PredictionResult{Prediction: Neutral, Probability: 0.7}, result.Confident(0.8))
+	assert.Equal(t, result, result.Confident(0))
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
 
 	"github.com/rs/zerolog/log"
 )
@@ -24,7 +25,10 @@ type RequestData struct {
 	Input Input  `json:"input"`
 }
 
-var conversationHistory map[string][]InputMessage
+var (
+	historyMutex        sync.Mutex
+	conversationHistory map[string][]InputMessage
+)
 
 const QianWenUrl = "https://dashscope.aliyuncs.com/api/v1/services/aigc/text-generation/generation"
 
@@ -44,16 +48,19 @@ func QianWen(userID string, newMessage string, key string) (string, error) {
 	updateConversationHistory(userID, newMessage)
 
 	var messages []InputMessage
+	historyMutex.Lock()
 	if hist, ok := conversationHistory[userID]; ok {
 		if len(hist) > 0 && hist[len(hist)-1].Role == "assistant" {
 			conversationHistory[userID] = append(hist, InputMessage{Role: "user", Content: newMessage})
 			messages = conversationHistory[userID]
 		} else {
+			historyMutex.Unlock()
 			return "", fmt.Errorf("failed to find assistant message in conversation history")
 		}
 	} else {
 		messages = []InputMessage{{Role: "user", Content: newMessage}}
 	}
+	historyMutex.Unlock()
 	input := Input{Messages: messages}
 
 	requestData := RequestData{
@@ -94,13 +101,17 @@ func QianWen(userID string, newMessage string, key string) (string, error) {
 	if output != "" {
 		latestReply := InputMessage{Role: "assistant", Content: output}
 		log.Info().Str("userID", userID).Str("message", newMessage).Msg(output)
+		historyMutex.Lock()
 		conversationHistory[userID] = append(messages, latestReply)
+		historyMutex.Unlock()
 	}
 
 	return output, nil
 }
 
 func updateConversationHistory(userID string, newMessage string) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
 	hist, ok := conversationHistory[userID]
 	if !ok || len(hist) == 0 {
 		hist = []InputMessage{{Role: "system", Content: "You are a helpful assistant."}}
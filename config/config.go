@@ -3,27 +3,82 @@ package config
 import (
 	"fmt"
 	"os"
+	"reflect"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
+// LanguageAnalyzer selects the sentiment analyzer for one language. An empty
+// PredictUrl inherits PREDICT_URL; an empty ModelPath uses the lexicon for the
+// language rather than SENTIMENT_MODEL_PATH, which is trained on one language.
+type LanguageAnalyzer struct {
+	Analyzer   string `mapstructure:"ANALYZER"`
+	PredictUrl string `mapstructure:"PREDICT_URL"`
+	ModelPath  string `mapstructure:"MODEL_PATH"`
+}
+
 type AppConfig struct {
-	Host           string `mapstructure:"HOST" default:"0.0.0.0"`
-	Port           int    `mapstructure:"PORT" default:"443"`
-	KeyFile        string `mapstructure:"KEY_FILE"`
-	CertFile       string `mapstructure:"CERT_FILE"`
-	Token          string `mapstructure:"TOKEN" required:"true"`
-	APIVersion     string `mapstructure:"LATEST_API_VERSION" default:"v19.0"`
-	PredictUrl     string `mapstructure:"PREDICT_URL" default:"http://127.0.0.1:5000/predict"`
-	QianwenKey     string `mapstructure:"QIANWEN_KEY" required:"true"`
-	PageID         string `mapstructure:"PAGE_ID" required:"true"`
-	PageAccesToken string `mapstructure:"PAGE_ACCESS_TOKEN" required:"true"`
-	AppSecret      string `mapstructure:"APP_SECRET"`
+	Host             string `mapstructure:"HOST" default:"0.0.0.0"`
+	Port             int    `mapstructure:"PORT" default:"443"`
+	KeyFile          string `mapstructure:"KEY_FILE"`
+	CertFile         string `mapstructure:"CERT_FILE"`
+	Token            string `mapstructure:"TOKEN" required:"true"`
+	APIVersion       string `mapstructure:"LATEST_API_VERSION" default:"v19.0"`
+	GraphAPIURL      string `mapstructure:"GRAPH_API_URL" default:"https://graph.facebook.com"`
+	PredictUrl       string `mapstructure:"PREDICT_URL" default:"http://127.0.0.1:5000/predict"`
+	QianwenKey       string `mapstructure:"QIANWEN_KEY" required:"true"`
+	PageID           string `mapstructure:"PAGE_ID" required:"true"`
+	PageAccesToken   string `mapstructure:"PAGE_ACCESS_TOKEN" required:"true"`
+	AppSecret        string `mapstructure:"APP_SECRET"`
+	EnforceSignature bool   `mapstructure:"ENFORCE_SIGNATURE" default:"true"`
+
+	// Each of the WorkerCount workers can have QueueSize events waiting.
+	WorkerCount     int           `mapstructure:"WORKER_COUNT" default:"4"`
+	QueueSize       int           `mapstructure:"QUEUE_SIZE" default:"100"`
+	ShutdownTimeout time.Duration `mapstructure:"SHUTDOWN_TIMEOUT" default:"30s"`
+
+	DedupeTTL       time.Duration `mapstructure:"DEDUPE_TTL" default:"24h"`
+	DedupeStorePath string        `mapstructure:"DEDUPE_STORE_PATH"`
+
+	AttachmentPolicy     map[string]string `mapstructure:"ATTACHMENT_POLICY"`
+	AttachmentStorageDir string            `mapstructure:"ATTACHMENT_STORAGE_DIR" default:"data/attachments"`
+
+	SenderActions bool `mapstructure:"SENDER_ACTIONS" default:"true"`
+	ReplyMaxParts int  `mapstructure:"REPLY_MAX_PARTS" default:"3"`
+
+	CommentReplyModes  map[string]string `mapstructure:"COMMENT_REPLY_MODES"`
+	SentimentThreshold float64           `mapstructure:"SENTIMENT_THRESHOLD" default:"0.6"`
+
+	SentimentAnalyzer string        `mapstructure:"SENTIMENT_ANALYZER" default:"fallback"`
+	SentimentTimeout  time.Duration `mapstructure:"SENTIMENT_TIMEOUT" default:"5s"`
+	SentimentModel    string        `mapstructure:"SENTIMENT_MODEL_PATH" default:"data/sentiment_model.json"`
+	// PredictBatchUrl, when set, scores many texts per request; without it
+	// batches are sent as SENTIMENT_BATCH_SIZE concurrent single requests.
+	PredictBatchUrl    string `mapstructure:"PREDICT_BATCH_URL"`
+	SentimentBatchSize int    `mapstructure:"SENTIMENT_BATCH_SIZE" default:"32"`
+	SentimentCacheSize int    `mapstructure:"SENTIMENT_CACHE_SIZE" default:"1000"`
+	// SentimentLanguages overrides the analyzer for texts detected to be in
+	// a language, keyed by language code such as "zh".
+	SentimentLanguages map[string]LanguageAnalyzer `mapstructure:"SENTIMENT_LANGUAGES"`
+
+	SendMaxAttempts    int           `mapstructure:"SEND_MAX_ATTEMPTS" default:"4"`
+	SendRetryBaseDelay time.Duration `mapstructure:"SEND_RETRY_BASE_DELAY" default:"500ms"`
+	SendRetryMaxDelay  time.Duration `mapstructure:"SEND_RETRY_MAX_DELAY" default:"10s"`
+
+	SendRateLimit      float64            `mapstructure:"SEND_RATE_LIMIT" default:"10"`
+	SendRateBurst      int                `mapstructure:"SEND_RATE_BURST" default:"20"`
+	PageSendRateLimits map[string]float64 `mapstructure:"PAGE_SEND_RATE_LIMITS"`
+
+	DeadLetterPath string `mapstructure:"DEAD_LETTER_PATH" default:"data/deadletters.json"`
+	// AdminToken enables the /admin endpoints; they are disabled while empty.
+	AdminToken string `mapstructure:"ADMIN_TOKEN"`
 }
 
 func LoadConfig(configPath string) (*AppConfig, error) {
 	viper.SetConfigFile(configPath)
 	viper.AutomaticEnv() // read in environment variables that match
+	setDefaults(reflect.TypeOf(AppConfig{}))
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err != nil && !os.IsNotExist(err) {
@@ -38,3 +93,20 @@ func LoadConfig(configPath string) (*AppConfig, error) {
 
 	return &appConf, nil
 }
+
+// setDefaults registers every field with viper so that environment variables
+// are picked up even when the key is missing from the config file.
+func setDefaults(configType reflect.Type) {
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		key := field.Tag.Get("mapstructure")
+		if key == "" {
+			continue
+		}
+		if value, ok := field.Tag.Lookup("default"); ok {
+			viper.SetDefault(key, value)
+		} else {
+			viper.BindEnv(key)
+		}
+	}
+}
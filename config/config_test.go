@@ -39,3 +39,39 @@ func TestConfig(t *testing.T) {
 		os.Setenv(k, v)
 	}
 }
+
+func TestConfigDefaults(t *testing.T) {
+	appConfig, err := LoadConfig("../config.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load test configuration: %v", err)
+	}
+
+	if !appConfig.EnforceSignature {
+		t.Errorf("Expected EnforceSignature to default to true")
+	}
+	if appConfig.AdminToken != "" {
+		t.Errorf("Expected the admin endpoints to be disabled by default, got ADMIN_TOKEN %q", appConfig.AdminToken)
+	}
+
+	os.Setenv("ENFORCE_SIGNATURE", "false")
+	defer os.Unsetenv("ENFORCE_SIGNATURE")
+	appConfig, err = LoadConfig("../config.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load test configuration: %v", err)
+	}
+
+	if appConfig.EnforceSignature {
+		t.Errorf("Expected EnforceSignature to be overridden by environment")
+	}
+}
+
+func TestConfigSentimentLanguages(t *testing.T) {
+	appConfig, err := LoadConfig("../config.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load test configuration: %v", err)
+	}
+
+	if appConfig.SentimentLanguages["zh"].Analyzer != "lexicon" {
+		t.Errorf("Expected the zh sentiment analyzer to be lexicon, got %+v", appConfig.SentimentLanguages)
+	}
+}
@@ -0,0 +1,311 @@
+// Package fakegraph is an in-process stand-in for the Graph API Send API and
+// comments API, used by tests to assert what the bot sent without network access.
+package fakegraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Graph API error codes the fake server can simulate.
+const (
+	CodeTooManyCalls    = 4
+	CodeRateLimit       = 613
+	CodeInvalidParam    = 100
+	CodeAccessToken     = 190
+	CodeUserUnavailable = 551
+	CodeOutsideWindow   = 10
+
+	SubcodeOutsideWindow = 2018278
+)
+
+// Request is a call received by the server.
+type Request struct {
+	Method      string
+	Path        string
+	Header      http.Header
+	Body        []byte
+	AccessToken string
+}
+
+// SentMessage is a decoded call to /{page-id}/messages. Private replies to
+// comments have a CommentID instead of a RecipientID.
+type SentMessage struct {
+	PageID       string
+	RecipientID  string
+	CommentID    string
+	SenderAction string
+	Text         string
+	Message      json.RawMessage
+	Time         time.Time
+}
+
+// Comment is a decoded call to /{object-id}/comments.
+type Comment struct {
+	ObjectID string
+	Message  string
+	Time     time.Time
+}
+
+type ErrorResponse struct {
+	Status  int
+	Code    int
+	Subcode int
+	Type    string
+	Message string
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	requests  []Request
+	messages  []SentMessage
+	comments  []Comment
+	failures  []ErrorResponse
+	headers   http.Header
+	rateLimit int
+	nextID    int
+	notify    chan struct{}
+}
+
+func NewServer() *Server {
+	s := &Server{headers: make(http.Header), notify: make(chan struct{}, 1)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Requests returns every call received so far, including failed ones.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Messages returns the accepted message sends, excluding sender actions.
+func (s *Server) Messages() []SentMessage {
+	return s.sent(func(m SentMessage) bool { return m.SenderAction == "" })
+}
+
+// Actions returns the accepted sender actions.
+func (s *Server) Actions() []SentMessage {
+	return s.sent(func(m SentMessage) bool { return m.SenderAction != "" })
+}
+
+// All returns accepted messages and sender actions in the order received.
+func (s *Server) All() []SentMessage {
+	return s.sent(func(m SentMessage) bool { return true })
+}
+
+// Comments returns the accepted comment replies.
+func (s *Server) Comments() []Comment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Comment(nil), s.comments...)
+}
+
+// WaitForMessages blocks until at least n messages were accepted or the
+// timeout expires, and returns the messages received so far.
+func (s *Server) WaitForMessages(n int, timeout time.Duration) []SentMessage {
+	s.wait(func() bool { return len(s.Messages()) >= n }, timeout)
+	return s.Messages()
+}
+
+// WaitForComments is WaitForMessages for comment replies.
+func (s *Server) WaitForComments(n int, timeout time.Duration) []Comment {
+	s.wait(func() bool { return len(s.Comments()) >= n }, timeout)
+	return s.Comments()
+}
+
+func (s *Server) wait(done func() bool, timeout time.Duration) {
+	deadline := time.After(timeout)
+	for !done() {
+		select {
+		case <-s.notify:
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// Fail queues an error response for the next call. Queued failures are
+// returned in order before normal handling resumes.
+func (s *Server) Fail(response ErrorResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if response.Status == 0 {
+		response.Status = http.StatusBadRequest
+	}
+	if response.Type == "" {
+		response.Type = "OAuthException"
+	}
+	s.failures = append(s.failures, response)
+}
+
+// FailNext queues n identical error responses.
+func (s *Server) FailNext(n int, status int, code int, subcode int) {
+	for i := 0; i < n; i++ {
+		s.Fail(ErrorResponse{Status: status, Code: code, Subcode: subcode, Message: fmt.Sprintf("simulated error %d", code)})
+	}
+}
+
+// RateLimitAfter makes every call after the first n accepted ones fail with
+// error code 613 until Reset is called. Zero disables the limit.
+func (s *Server) RateLimitAfter(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimit = n
+}
+
+// SetHeader adds a header, such as X-Business-Use-Case-Usage, to every
+// response.
+func (s *Server) SetHeader(key string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headers.Set(key, value)
+}
+
+// Reset forgets recorded calls, queued failures, limits and headers.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+	s.messages = nil
+	s.comments = nil
+	s.failures = nil
+	s.rateLimit = 0
+	s.headers = make(http.Header)
+}
+
+func (s *Server) sent(keep func(SentMessage) bool) []SentMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []SentMessage
+	for _, message := range s.messages {
+		if keep(message) {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	token := r.URL.Query().Get("access_token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body, AccessToken: token})
+	for key, values := range s.headers {
+		w.Header()[key] = values
+	}
+
+	if len(s.failures) > 0 {
+		failure := s.failures[0]
+		s.failures = s.failures[1:]
+		writeError(w, failure)
+		return
+	}
+	if s.rateLimit > 0 && len(s.messages)+len(s.comments) >= s.rateLimit {
+		writeError(w, ErrorResponse{Status: http.StatusBadRequest, Code: CodeRateLimit, Type: "OAuthException", Message: "Calls to this api have exceeded the rate limit."})
+		return
+	}
+	if token == "" {
+		writeError(w, ErrorResponse{Status: http.StatusBadRequest, Code: CodeAccessToken, Type: "OAuthException", Message: "An access token is required to request this resource."})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method != http.MethodPost || len(parts) != 3 || (parts[2] != "messages" && parts[2] != "comments") {
+		writeError(w, ErrorResponse{Status: http.StatusBadRequest, Code: CodeInvalidParam, Type: "GraphMethodException", Message: fmt.Sprintf("Unsupported request: %s %s", r.Method, r.URL.Path)})
+		return
+	}
+	if parts[2] == "comments" {
+		s.handleComment(w, parts[1], body)
+		return
+	}
+
+	var payload struct {
+		Recipient struct {
+			ID        string `json:"id"`
+			CommentID string `json:"comment_id"`
+		} `json:"recipient"`
+		SenderAction string          `json:"sender_action"`
+		Message      json.RawMessage `json:"message"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		writeError(w, ErrorResponse{Status: http.StatusBadRequest, Code: CodeInvalidParam, Type: "OAuthException", Message: err.Error()})
+		return
+	}
+	var text struct {
+		Text string `json:"text"`
+	}
+	json.Unmarshal(payload.Message, &text)
+
+	s.messages = append(s.messages, SentMessage{
+		PageID:       parts[1],
+		RecipientID:  payload.Recipient.ID,
+		CommentID:    payload.Recipient.CommentID,
+		SenderAction: payload.SenderAction,
+		Text:         text.Text,
+		Message:      payload.Message,
+		Time:         time.Now(),
+	})
+	s.notifyWaiters()
+
+	response := map[string]string{"recipient_id": payload.Recipient.ID}
+	if payload.SenderAction == "" {
+		s.nextID++
+		response["message_id"] = fmt.Sprintf("m_fake%d", s.nextID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) handleComment(w http.ResponseWriter, objectID string, body []byte) {
+	var payload struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Message == "" {
+		writeError(w, ErrorResponse{Status: http.StatusBadRequest, Code: CodeInvalidParam, Type: "OAuthException", Message: "(#100) Missing message or attachment"})
+		return
+	}
+
+	s.comments = append(s.comments, Comment{ObjectID: objectID, Message: payload.Message, Time: time.Now()})
+	s.notifyWaiters()
+
+	s.nextID++
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": fmt.Sprintf("%s_fake%d", objectID, s.nextID)})
+}
+
+func (s *Server) notifyWaiters() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func writeError(w http.ResponseWriter, response ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.Status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message":       response.Message,
+			"type":          response.Type,
+			"code":          response.Code,
+			"error_subcode": response.Subcode,
+			"fbtrace_id":    "AfakeTraceID",
+		},
+	})
+}
@@ -0,0 +1,88 @@
+package fakegraph
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func post(t *testing.T, s *Server, path string, body string) (int, map[string]interface{}) {
+	req, err := http.NewRequest(http.MethodPost, s.URL+path, bytes.NewBufferString(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&decoded))
+	return resp.StatusCode, decoded
+}
+
+func TestServerRecordsMessages(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	status, body := post(t, s, "/v19.0/page/messages", `{"recipient":{"id":"psid"},"message":{"text":"hello"}}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "m_fake1", body["message_id"])
+	post(t, s, "/v19.0/page/messages", `{"recipient":{"id":"psid"},"sender_action":"typing_on"}`)
+
+	messages := s.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "page", messages[0].PageID)
+	assert.Equal(t, "psid", messages[0].RecipientID)
+	assert.Equal(t, "hello", messages[0].Text)
+	require.Len(t, s.Actions(), 1)
+	assert.Equal(t, "typing_on", s.Actions()[0].SenderAction)
+	assert.Equal(t, "token", s.Requests()[0].AccessToken)
+}
+
+func TestServerSimulatesErrors(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.FailNext(1, http.StatusBadRequest, CodeOutsideWindow, SubcodeOutsideWindow)
+	status, body := post(t, s, "/v19.0/page/messages", `{"recipient":{"id":"psid"},"message":{"text":"hello"}}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	graphErr := body["error"].(map[string]interface{})
+	assert.Equal(t, float64(CodeOutsideWindow), graphErr["code"])
+	assert.Equal(t, float64(SubcodeOutsideWindow), graphErr["error_subcode"])
+
+	s.RateLimitAfter(1)
+	status, _ = post(t, s, "/v19.0/page/messages", `{"recipient":{"id":"psid"},"message":{"text":"hello"}}`)
+	assert.Equal(t, http.StatusOK, status)
+	status, body = post(t, s, "/v19.0/page/messages", `{"recipient":{"id":"psid"},"message":{"text":"again"}}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, float64(CodeRateLimit), body["error"].(map[string]interface{})["code"])
+
+	s.Reset()
+	assert.Empty(t, s.Requests())
+	status, _ = post(t, s, "/v19.0/page/unknown", `{}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestServerRecordsComments(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	status, body := post(t, s, "/v19.0/111_222/comments", `{"message":"thanks!"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "111_222_fake1", body["id"])
+	status, _ = post(t, s, "/v19.0/111_222/comments", `{}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	post(t, s, "/v19.0/page/messages", `{"recipient":{"comment_id":"111_222"},"message":{"text":"hello"}}`)
+
+	comments := s.Comments()
+	require.Len(t, comments, 1)
+	assert.Equal(t, "111_222", comments[0].ObjectID)
+	assert.Equal(t, "thanks!", comments[0].Message)
+	messages := s.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "111_222", messages[0].CommentID)
+	assert.Empty(t, messages[0].RecipientID)
+}
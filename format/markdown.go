@@ -0,0 +1,236 @@
+package format
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Link is a Markdown link found while rendering.
+type Link struct {
+	Text string
+	URL  string
+}
+
+// Result is Markdown rendered as plain text for Messenger.
+type Result struct {
+	// Text keeps link targets inline, e.g. "our store (https://...)".
+	Text string
+	// Plain only keeps the link text, for when links are sent as buttons.
+	Plain string
+	Links []Link
+}
+
+var (
+	fenceLine     = regexp.MustCompile("^\\s*(```|~~~)")
+	headingLine   = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	ruleLine      = regexp.MustCompile(`^\s{0,3}(-(\s*-){2,}|\*(\s*\*){2,}|_(\s*_){2,})\s*$`)
+	quoteLine     = regexp.MustCompile(`^\s{0,3}>\s?(.*)$`)
+	bulletLine    = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	orderedLine   = regexp.MustCompile(`^(\s*)(\d+)[.)]\s+(.*)$`)
+	tableSepLine  = regexp.MustCompile(`^\s*\|?\s*:?-{3,}:?\s*(\|\s*:?-{3,}:?\s*)*\|?\s*$`)
+	codeSpan      = regexp.MustCompile("`([^`]+)`")
+	linkPattern   = regexp.MustCompile(`(!?)\[([^\]]*)\]\(([^)\s]+)(?:\s+"[^"]*")?\)|<(https?://[^>\s]+)>`)
+	autolink      = regexp.MustCompile(`<(https?://[^>\s]+)>`)
+	boldPattern   = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	strikePattern = regexp.MustCompile(`~~(.+?)~~`)
+	italicStar    = regexp.MustCompile(`(^|[^\w*])\*([^*\s](?:[^*]*[^*\s])?)\*([^\w*]|$)`)
+	italicUnder   = regexp.MustCompile(`(^|[^\w])_([^_\s](?:[^_]*[^_\s])?)_([^\w]|$)`)
+	blankLines    = regexp.MustCompile(`\n{3,}`)
+)
+
+// Markdown renders the Markdown produced by the assistant as text that reads
+// well in Messenger, which shows Markdown syntax literally.
+func Markdown(markdown string) Result {
+	var r renderer
+	lines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+
+	inFence := false
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+
+		if fenceLine.MatchString(line) {
+			inFence = !inFence
+			r.blank()
+			continue
+		}
+		if inFence {
+			r.raw(line)
+			continue
+		}
+
+		if strings.Contains(line, "|") && i+1 < len(lines) && tableSepLine.MatchString(lines[i+1]) {
+			end := i + 2
+			for end < len(lines) && strings.Contains(lines[end], "|") && strings.TrimSpace(lines[end]) != "" {
+				end++
+			}
+			r.table(lines[i], lines[i+2:end])
+			i = end - 1
+			continue
+		}
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			r.blank()
+		case ruleLine.MatchString(line):
+			r.blank()
+		case headingLine.MatchString(line):
+			r.blank()
+			r.inline("", headingLine.FindStringSubmatch(line)[1])
+			r.blank()
+		case quoteLine.MatchString(line):
+			r.inline("", quoteLine.FindStringSubmatch(line)[1])
+		case bulletLine.MatchString(line):
+			m := bulletLine.FindStringSubmatch(line)
+			r.inline(indent(m[1])+"• ", m[2])
+		case orderedLine.MatchString(line):
+			m := orderedLine.FindStringSubmatch(line)
+			r.inline(indent(m[1])+m[2]+". ", m[3])
+		default:
+			r.inline("", strings.TrimSpace(line))
+		}
+	}
+
+	return Result{
+		Text:  r.text.finish(),
+		Plain: r.plain.finish(),
+		Links: r.links,
+	}
+}
+
+func indent(leading string) string {
+	width := len(strings.ReplaceAll(leading, "\t", "    "))
+	return strings.Repeat("  ", width/2)
+}
+
+type output struct {
+	lines []string
+}
+
+func (o *output) add(line string) {
+	o.lines = append(o.lines, line)
+}
+
+func (o *output) finish() string {
+	text := strings.Join(o.lines, "\n")
+	return strings.TrimSpace(blankLines.ReplaceAllString(text, "\n\n"))
+}
+
+type renderer struct {
+	text  output
+	plain output
+	links []Link
+}
+
+func (r *renderer) blank() {
+	r.raw("")
+}
+
+func (r *renderer) raw(line string) {
+	r.text.add(line)
+	r.plain.add(line)
+}
+
+func (r *renderer) inline(prefix string, markdown string) {
+	text, plain := r.renderInline(markdown)
+	r.text.add(prefix + text)
+	r.plain.add(prefix + plain)
+}
+
+// table renders each row as a bullet of "header: value" pairs, since
+// Messenger cannot align columns.
+func (r *renderer) table(header string, rows []string) {
+	headers := tableCells(header)
+	r.blank()
+	for _, row := range rows {
+		var text, plain []string
+		for i, cell := range tableCells(row) {
+			cellText, cellPlain := r.renderInline(cell)
+			if i < len(headers) && headers[i] != "" {
+				name, _ := r.renderInline(headers[i])
+				cellText = name + ": " + cellText
+				cellPlain = name + ": " + cellPlain
+			}
+			text = append(text, cellText)
+			plain = append(plain, cellPlain)
+		}
+		r.text.add("• " + strings.Join(text, "; "))
+		r.plain.add("• " + strings.Join(plain, "; "))
+	}
+	r.blank()
+}
+
+func tableCells(row string) []string {
+	row = strings.TrimSpace(row)
+	row = strings.TrimPrefix(row, "|")
+	row = strings.TrimSuffix(row, "|")
+	cells := strings.Split(row, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+// renderInline strips inline Markdown, leaving code spans untouched.
+func (r *renderer) renderInline(markdown string) (string, string) {
+	var text, plain strings.Builder
+	last := 0
+	for _, loc := range codeSpan.FindAllStringSubmatchIndex(markdown, -1) {
+		t, p := r.renderLinks(markdown[last:loc[0]])
+		text.WriteString(t)
+		plain.WriteString(p)
+		code := markdown[loc[2]:loc[3]]
+		text.WriteString(code)
+		plain.WriteString(code)
+		last = loc[1]
+	}
+	t, p := r.renderLinks(markdown[last:])
+	text.WriteString(t)
+	plain.WriteString(p)
+	return text.String(), plain.String()
+}
+
+func (r *renderer) renderLinks(markdown string) (string, string) {
+	var text, plain strings.Builder
+	last := 0
+	for _, m := range linkPattern.FindAllStringSubmatchIndex(markdown, -1) {
+		before := stripEmphasis(markdown[last:m[0]])
+		text.WriteString(before)
+		plain.WriteString(before)
+
+		var label, url string
+		if m[8] >= 0 {
+			// An autolink such as <https://...> is its own text.
+			url = markdown[m[8]:m[9]]
+			label = url
+		} else {
+			label = strings.TrimSpace(stripEmphasis(markdown[m[4]:m[5]]))
+			url = markdown[m[6]:m[7]]
+		}
+		if label == "" || label == url {
+			text.WriteString(url)
+			plain.WriteString(url)
+		} else {
+			text.WriteString(label + " (" + url + ")")
+			plain.WriteString(label)
+		}
+		r.links = append(r.links, Link{Text: label, URL: url})
+		last = m[1]
+	}
+	rest := stripEmphasis(markdown[last:])
+	text.WriteString(rest)
+	plain.WriteString(rest)
+	return text.String(), plain.String()
+}
+
+func stripEmphasis(text string) string {
+	text = autolink.ReplaceAllString(text, "$1")
+	text = boldPattern.ReplaceAllString(text, "$1$2")
+	text = strikePattern.ReplaceAllString(text, "$1")
+	// Emphasis must not touch word characters, so 2*3*4 and snake_case
+	// survive. Adjacent spans share a boundary, hence the second pass.
+	for i := 0; i < 2; i++ {
+		text = italicStar.ReplaceAllString(text, "$1$2$3")
+		text = italicUnder.ReplaceAllString(text, "$1$2$3")
+	}
+	return text
+}
@@ -0,0 +1,51 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkdownInline(t *testing.T) {
+	result := Markdown("**Order** status is _shipped_, see `track_id` or ~~call~~ [our site](https://example.com/track).")
+
+	assert.Equal(t, "Order status is shipped, see track_id or call our site (https://example.com/track).", result.Text)
+	assert.Equal(t, "Order status is shipped, see track_id or call our site.", result.Plain)
+	assert.Equal(t, []Link{{Text: "our site", URL: "https://example.com/track"}}, result.Links)
+}
+
+func TestMarkdownBlocks(t *testing.T) {
+	markdown := "# Shipping\n\nOptions:\n- Standard\n  - 5 days\n* Express\n1. Pay\n2. Wait\n\n> Note: *free* over $50\n\n---\n```go\nfmt.Println(\"**raw**\")\n```\n"
+
+	expected := "Shipping\n\nOptions:\n• Standard\n  • 5 days\n• Express\n1. Pay\n2. Wait\n\nNote: free over $50\n\nfmt.Println(\"**raw**\")"
+	assert.Equal(t, expected, Markdown(markdown).Text)
+}
+
+func TestMarkdownTable(t *testing.T) {
+	markdown := "Plans:\n\n| Plan | Price |\n|------|:-----:|\n| Basic | $5 |\n| **Pro** | $10 |\n\nDone."
+
+	assert.Equal(t, "Plans:\n\n• Plan: Basic; Price: $5\n• Plan: Pro; Price: $10\n\nDone.", Markdown(markdown).Text)
+}
+
+func TestMarkdownLinks(t *testing.T) {
+	result := Markdown("Visit <https://example.com> or ![logo](https://example.com/logo.png) or [https://a.io](https://a.io).")
+
+	assert.Equal(t, "Visit https://example.com or logo (https://example.com/logo.png) or https://a.io.", result.Text)
+	assert.Equal(t, []Link{{Text: "https://example.com", URL: "https://example.com"}, {Text: "logo", URL: "https://example.com/logo.png"}, {Text: "https://a.io", URL: "https://a.io"}}, result.Links)
+}
+
+func TestMarkdownAutolinks(t *testing.T) {
+	result := Markdown("Track it at <https://example.com/track>, not `<https://example.com/code>`")
+
+	assert.Equal(t, "Track it at https://example.com/track, not <https://example.com/code>", result.Text)
+	assert.Equal(t, result.Text, result.Plain)
+	assert.Equal(t, []Link{{Text: "https://example.com/track", URL: "https://example.com/track"}}, result.Links)
+}
+
+func TestMarkdownLeavesSnakeCase(t *testing.T) {
+	assert.Equal(t, "set page_access_token and 2*3*4", Markdown("set page_access_token and 2*3*4").Text)
+}
+
+func TestMarkdownAdjacentEmphasis(t *testing.T) {
+	assert.Equal(t, "a b c", Markdown("*a* *b* _c_").Text)
+}
@@ -5,19 +5,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/qew21/fb-messenger/analysis"
 	"github.com/qew21/fb-messenger/config"
 	"github.com/qew21/fb-messenger/messenger"
+	"github.com/qew21/fb-messenger/webhook"
 
 	"github.com/julienschmidt/httprouter"
 
@@ -29,6 +36,9 @@ var (
 	receivedUpdatesMutex sync.RWMutex
 	receivedUpdates      = make([]map[string]interface{}, 0)
 	appConfig            *config.AppConfig
+	dispatcher           *messenger.Dispatcher
+	processor            *messenger.Processor
+	deadLetters          messenger.DeadLetterStore
 )
 
 func main() {
@@ -56,25 +66,85 @@ func main() {
 		log.Fatal().Err(err).Msg("Error loading configuration")
 	}
 
+	deadLetters = messenger.NewFileDeadLetterStore(appConfig.DeadLetterPath)
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
+	dispatcher, processor, err = newDispatcher(appConfig, false)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error creating event dispatcher")
+	}
+
 	router := httprouter.New()
 
 	router.HandlerFunc(http.MethodGet, "/", handleGetIndex)
 	router.HandlerFunc(http.MethodGet, "/facebook", handleGetWebHook)
 	router.HandlerFunc(http.MethodPost, "/facebook", handlePostWebHook)
+	router.HandlerFunc(http.MethodGet, "/metrics", handleGetMetrics)
+	router.HandlerFunc(http.MethodGet, "/admin/deadletters", requireAdmin(handleGetDeadLetters))
+	router.HandlerFunc(http.MethodPost, "/admin/deadletters/replay", requireAdmin(handleReplayDeadLetters))
 	router.HandlerFunc(http.MethodGet, "/privacy", privacyHandler)
 	router.HandlerFunc(http.MethodGet, "/terms", termsHandler)
 
 	addr := fmt.Sprintf("%s:%d", appConfig.Host, appConfig.Port)
-	log.Info().Str("address", addr).Msg("Starting HTTP server")
-	if appConfig.Port == 443 {
-		err = http.ListenAndServeTLS(addr, appConfig.CertFile, appConfig.KeyFile, router)
-	} else {
-		err = http.ListenAndServe(addr, router)
+	server := &http.Server{Addr: addr, Handler: router}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Info().Str("address", addr).Msg("Starting HTTP server")
+		var err error
+		if appConfig.Port == 443 {
+			err = server.ListenAndServeTLS(appConfig.CertFile, appConfig.KeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal().Str("address", addr).Err(err).Msg("Error starting HTTP server")
+		}
+	}()
+
+	<-ctx.Done()
+	log.Info().Msg("Shutting down, draining queued events")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), appConfig.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Warn().Err(err).Msg("Error shutting down HTTP server")
 	}
-	if err != nil {
-		log.Fatal().Str("address", addr).Err(err).Msg("Error starting HTTP server")
+	if err := dispatcher.Close(shutdownCtx); err != nil {
+		log.Warn().Err(err).Msg("Error draining event queue")
 	}
+}
 
+// newDispatcher returns a dispatcher and the processor it hands events to.
+func newDispatcher(appConfig *config.AppConfig, testMode bool) (*messenger.Dispatcher, *messenger.Processor, error) {
+	var store messenger.DedupeStore = messenger.NewMemoryDedupeStore()
+	if appConfig.DedupeStorePath != "" {
+		if err := os.MkdirAll(filepath.Dir(appConfig.DedupeStorePath), 0755); err != nil {
+			return nil, nil, fmt.Errorf("failed to create dedupe store directory: %w", err)
+		}
+		fileStore, err := messenger.OpenFileDedupeStore(appConfig.DedupeStorePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		store = fileStore
+	}
+
+	p, err := messenger.NewProcessor(appConfig, testMode)
+	if err != nil {
+		if closer, ok := store.(io.Closer); ok {
+			closer.Close()
+		}
+		return nil, nil, err
+	}
+	p.DeadLetters = deadLetters
+	d := messenger.NewDispatcher(appConfig.WorkerCount, appConfig.QueueSize, p.ProcessEvent)
+	d.SetDedupeStore(store, appConfig.DedupeTTL)
+	d.SetPrefetch(p.PrefetchSentiments, appConfig.SentimentTimeout)
+	return d, p, nil
 }
 
 func handleGetIndex(w http.ResponseWriter, r *http.Request) {
@@ -110,30 +180,69 @@ func handlePostWebHook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var payload map[string]interface{}
-	err = json.Unmarshal(payloadBytes, &payload)
+	if err := webhook.VerifySignature(appConfig.AppSecret, payloadBytes, r.Header); err != nil {
+		if appConfig.EnforceSignature {
+			log.Warn().Err(err).Msg("Rejected webhook with invalid signature")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		log.Warn().Err(err).Msg("Webhook signature check failed, processing anyway")
+	}
+
+	update, err := webhook.Decode(payloadBytes)
 	if err != nil {
 		log.Warn().Err(err).Msg("Error decoding payload")
 		handleJSONUnmarshalError(w, err)
 		return
 	}
 
-	err = messenger.ProcessMessage(payload, appConfig, r.URL.Path == "/test")
-	if err != nil {
-		log.Warn().Err(err).Str("object", payload["object"].(string)).Msg("ProcessMessage Failed")
+	err = dispatcher.Submit(update)
+	if errors.Is(err, messenger.ErrQueueFull) || errors.Is(err, messenger.ErrDispatcherClosed) {
+		// A non-200 makes Facebook redeliver the batch later.
+		log.Warn().Err(err).Interface("stats", dispatcher.Stats()).Msg("Webhook not accepted")
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		log.Warn().Err(err).Str("object", update.Object).Msg("Submit Failed")
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, "Webhook processed successfully.")
 }
 
+type metrics struct {
+	messenger.DispatcherStats
+	Reactions  map[string]int `json:"reactions"`
+	Sentiments map[string]int `json:"sentiments"`
+	Aspects    map[string]int `json:"negative_aspects"`
+	// SentimentCache is reported when SENTIMENT_CACHE_SIZE enables the cache.
+	SentimentCache *analysis.CacheStats `json:"sentiment_cache,omitempty"`
+}
+
+func handleGetMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	report := metrics{
+		DispatcherStats: dispatcher.Stats(),
+		Reactions:       processor.Reactions.Totals(),
+		Sentiments:      processor.Sentiments.Counts(),
+		Aspects:         processor.Sentiments.AspectCounts(),
+	}
+	if cache, ok := processor.Analyzer.(*analysis.CachedAnalyzer); ok {
+		stats := cache.Stats()
+		report.SentimentCache = &stats
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
 func handleJSONUnmarshalError(w http.ResponseWriter, err error) {
-	if serr, ok := err.(*json.SyntaxError); ok {
+	var serr *json.SyntaxError
+	if errors.As(err, &serr) {
 		http.Error(w, serr.Error(), http.StatusBadRequest)
 		return
 	}
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	http.Error(w, err.Error(), http.StatusBadRequest)
 }
 
 func termsHandler(w http.ResponseWriter, r *http.Request) {
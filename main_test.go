@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -9,8 +10,12 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/qew21/fb-messenger/config"
+	"github.com/qew21/fb-messenger/fakegraph"
+	"github.com/qew21/fb-messenger/messenger"
+	"github.com/qew21/fb-messenger/webhook"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -19,6 +24,9 @@ func setupTestConfiguration() {
 	if appConfig == nil {
 		appConfig, _ = config.LoadConfig("config.yaml")
 	}
+	if dispatcher == nil {
+		dispatcher, processor, _ = newDispatcher(appConfig, true)
+	}
 }
 
 func TestHandlePostWebHook(t *testing.T) {
@@ -29,6 +37,16 @@ func TestHandlePostWebHook(t *testing.T) {
 		{"Message", "message.json"},
 		{"Feed", "feed.json"},
 		{"Ratings", "ratings.json"},
+		{"Batch", "batch.json"},
+		{"Echo", "echo.json"},
+		{"Delivery", "delivery.json"},
+		{"Read", "read.json"},
+		{"Postback", "postback.json"},
+		{"QuickReply", "quick_reply.json"},
+		{"Image", "image.json"},
+		{"FeedComment", "feed_comment.json"},
+		{"FeedCommentRemove", "feed_comment_remove.json"},
+		{"FeedReaction", "feed_reaction.json"},
 	}
 	setupTestConfiguration()
 	for _, tc := range testCases {
@@ -46,6 +64,7 @@ func TestHandlePostWebHook(t *testing.T) {
 
 			req, err := http.NewRequest(http.MethodPost, "/test", bytes.NewReader(byteValue))
 			assert.NoError(t, err)
+			req.Header.Set(webhook.SignatureHeader, webhook.Sign(appConfig.AppSecret, byteValue))
 			recorder := httptest.NewRecorder()
 
 			handlePostWebHook(recorder, req)
@@ -55,3 +74,123 @@ func TestHandlePostWebHook(t *testing.T) {
 		})
 	}
 }
+
+func TestHandlePostWebHookSignature(t *testing.T) {
+	setupTestConfiguration()
+	byteValue, err := ioutil.ReadFile(filepath.Join("mock", "message.json"))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		signature string
+		enforce   bool
+		expected  int
+	}{
+		{"Missing", "", true, http.StatusForbidden},
+		{"Invalid", webhook.Sign("wrong_secret", byteValue), true, http.StatusForbidden},
+		{"LogOnly", webhook.Sign("wrong_secret", byteValue), false, http.StatusOK},
+	}
+
+	enforce := appConfig.EnforceSignature
+	defer func() { appConfig.EnforceSignature = enforce }()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			appConfig.EnforceSignature = tc.enforce
+			req, err := http.NewRequest(http.MethodPost, "/test", bytes.NewReader(byteValue))
+			require.NoError(t, err)
+			if tc.signature != "" {
+				req.Header.Set(webhook.SignatureHeader, tc.signature)
+			}
+			recorder := httptest.NewRecorder()
+
+			handlePostWebHook(recorder, req)
+
+			assert.Equal(t, tc.expected, recorder.Code)
+		})
+	}
+}
+
+func TestHandlePostWebHookQueueFull(t *testing.T) {
+	setupTestConfiguration()
+	byteValue, err := ioutil.ReadFile(filepath.Join("mock", "batch.json"))
+	require.NoError(t, err)
+
+	running := dispatcher
+	release := make(chan struct{})
+	dispatcher = messenger.NewDispatcher(1, 1, func(event messenger.Event) error {
+		<-release
+		return nil
+	})
+	defer func() {
+		close(release)
+		dispatcher.Close(context.Background())
+		dispatcher = running
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, "/facebook", bytes.NewReader(byteValue))
+	require.NoError(t, err)
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(appConfig.AppSecret, byteValue))
+	recorder := httptest.NewRecorder()
+
+	handlePostWebHook(recorder, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handleGetMetrics(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	var stats messenger.DispatcherStats
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &stats))
+	assert.Equal(t, uint64(4), stats.Rejected)
+	assert.Equal(t, 1, stats.QueueSize)
+	assert.Contains(t, recorder.Body.String(), `"reactions":{}`)
+}
+
+func TestHandlePostWebHookReplies(t *testing.T) {
+	setupTestConfiguration()
+	server := fakegraph.NewServer()
+	defer server.Close()
+
+	testConfig := *appConfig
+	testConfig.GraphAPIURL = server.URL
+	testConfig.AttachmentPolicy = nil
+
+	running, runningProcessor := dispatcher, processor
+	var err error
+	dispatcher, processor, err = newDispatcher(&testConfig, false)
+	require.NoError(t, err)
+	defer func() { dispatcher, processor = running, runningProcessor }()
+
+	testCases := []struct {
+		filename string
+		text     string
+	}{
+		{"ratings.json", "We're so glad to hear that! Could you share more about what you enjoyed?"},
+		{"image.json", "Thanks for the photo! I can only read text messages for now, so could you tell me in words how I can help?"},
+	}
+	for _, tc := range testCases {
+		byteValue, err := ioutil.ReadFile(filepath.Join("mock", tc.filename))
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, "/facebook", bytes.NewReader(byteValue))
+		require.NoError(t, err)
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(testConfig.AppSecret, byteValue))
+		recorder := httptest.NewRecorder()
+
+		handlePostWebHook(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+	}
+
+	// Positive reviews are answered publicly under the review, messages in
+	// Messenger.
+	comments := server.WaitForComments(1, 5*time.Second)
+	messages := server.WaitForMessages(1, 5*time.Second)
+	require.NoError(t, dispatcher.Close(context.Background()))
+	require.Len(t, comments, 1)
+	assert.Equal(t, "44444444_444444444", comments[0].ObjectID)
+	assert.Equal(t, testCases[0].text, comments[0].Message)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "44444444", messages[0].RecipientID)
+	assert.Equal(t, testCases[1].text, messages[0].Text)
+	assert.Equal(t, testConfig.PageAccesToken, server.Requests()[0].AccessToken)
+}
@@ -0,0 +1,220 @@
+package messenger
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/qew21/fb-messenger/webhook"
+	"github.com/rs/zerolog/log"
+)
+
+// Policies for inbound attachments, configured per type in ATTACHMENT_POLICY.
+const (
+	AttachmentIgnore      = "ignore"
+	AttachmentAcknowledge = "acknowledge"
+	AttachmentAssistant   = "assistant"
+	AttachmentDownload    = "download"
+)
+
+// maxAttachmentSize matches the 25MB Messenger attachment limit.
+const maxAttachmentSize = 25 << 20
+
+const (
+	DefaultAttachmentReply    = "Thanks for the %s! I can only read text messages for now, so could you tell me in words how I can help?"
+	DownloadedAttachmentReply = "Thanks, we've received your %s and our team will take a look."
+)
+
+var (
+	attachmentClient = &http.Client{Timeout: 30 * time.Second, CheckRedirect: checkAttachmentRedirect}
+	unsafeFileChars  = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+	// attachmentHosts are the domains Messenger serves attachments from;
+	// downloads from anywhere else are refused so a forged webhook cannot make
+	// the server fetch internal URLs.
+	attachmentHosts = []string{"fbcdn.net", "fbsbx.com"}
+)
+
+// checkAttachmentPolicies rejects ATTACHMENT_POLICY values that are not one
+// of the policies above.
+func checkAttachmentPolicies(policies map[string]string) error {
+	kinds := make([]string, 0, len(policies))
+	for kind := range policies {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		switch policies[kind] {
+		case AttachmentIgnore, AttachmentAcknowledge, AttachmentAssistant, AttachmentDownload:
+		default:
+			return fmt.Errorf("unknown attachment policy %q for %s", policies[kind], kind)
+		}
+	}
+	return nil
+}
+
+func (p *Processor) attachmentPolicy(kind string) string {
+	if policy, ok := p.appConfig.AttachmentPolicy[kind]; ok {
+		return policy
+	}
+	return AttachmentAcknowledge
+}
+
+// processAttachments applies the configured policy to every attachment of a
+// message and sends at most one acknowledgement for the whole message.
+func (p *Processor) processAttachments(event webhook.MessagingEvent) error {
+	senderID := getMessageSender(event)
+
+	var reply string
+	for i, attachment := range event.Message.Attachments {
+		kind := attachment.Kind()
+		switch p.attachmentPolicy(kind) {
+		case AttachmentIgnore:
+			continue
+		case AttachmentAssistant:
+			if prompt, ok := assistantPrompt(attachment); ok {
+				if err := p.replyWithAssistant(senderID, prompt); err != nil {
+					return err
+				}
+				continue
+			}
+		case AttachmentDownload:
+			name := fmt.Sprintf("%s_%d", event.Message.Mid, i)
+			filePath, err := DownloadAttachment(attachment.Payload.URL, p.appConfig.AttachmentStorageDir, name)
+			if err != nil {
+				return fmt.Errorf("failed to download %s attachment from %s: %w", kind, senderID, err)
+			}
+			log.Info().Str("senderID", senderID).Str("type", kind).Str("path", filePath).Msg("Attachment saved")
+			if reply == "" {
+				reply = fmt.Sprintf(DownloadedAttachmentReply, attachmentName(kind))
+			}
+			continue
+		}
+		if reply == "" {
+			reply = fmt.Sprintf(DefaultAttachmentReply, attachmentName(kind))
+		}
+	}
+
+	if reply == "" {
+		return nil
+	}
+	return p.send(senderID, reply)
+}
+
+// assistantPrompt describes an attachment as text for the assistant, which
+// only understands text. Types it cannot describe usefully are reported as
+// not handled.
+func assistantPrompt(attachment webhook.Attachment) (string, bool) {
+	switch attachment.Kind() {
+	case webhook.AttachmentLocation:
+		if c := attachment.Payload.Coordinates; c != nil {
+			return fmt.Sprintf("I am sharing my location: latitude %f, longitude %f.", c.Lat, c.Long), true
+		}
+	case webhook.AttachmentFallback:
+		link := attachment.URL
+		if link == "" {
+			link = attachment.Payload.URL
+		}
+		title := attachment.Title
+		if title == "" {
+			title = attachment.Payload.Title
+		}
+		if link != "" {
+			return strings.TrimSpace(fmt.Sprintf("I am sharing this link: %s %s", title, link)), true
+		}
+	}
+	return "", false
+}
+
+func attachmentName(kind string) string {
+	switch kind {
+	case webhook.AttachmentImage:
+		return "photo"
+	case webhook.AttachmentAudio:
+		return "voice message"
+	case webhook.AttachmentFallback:
+		return "link"
+	case "":
+		return "attachment"
+	default:
+		return kind
+	}
+}
+
+// checkAttachmentURL only allows https URLs on the Facebook CDN.
+func checkAttachmentURL(attachmentURL *url.URL) error {
+	if attachmentURL.Scheme != "https" {
+		return fmt.Errorf("attachment url %s is not https", attachmentURL.Redacted())
+	}
+	host := strings.ToLower(attachmentURL.Hostname())
+	for _, allowed := range attachmentHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("attachment host %q is not a Facebook CDN", host)
+}
+
+func checkAttachmentRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after %d redirects", len(via))
+	}
+	return checkAttachmentURL(req.URL)
+}
+
+// DownloadAttachment saves the attachment at rawURL, which must be on the
+// Facebook CDN, into dir, naming it after name plus the extension found in
+// the URL, and returns the file path.
+func DownloadAttachment(rawURL string, dir string, name string) (string, error) {
+	if rawURL == "" {
+		return "", fmt.Errorf("attachment has no url")
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid attachment url: %w", err)
+	}
+	if err := checkAttachmentURL(parsed); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create attachment directory: %w", err)
+	}
+
+	resp, err := attachmentClient.Get(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("server returned status code %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxAttachmentSize {
+		return "", fmt.Errorf("attachment exceeds %d bytes", maxAttachmentSize)
+	}
+
+	filePath := filepath.Join(dir, unsafeFileChars.ReplaceAllString(name, "_")+path.Ext(parsed.Path))
+	file, err := os.Create(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create attachment file: %w", err)
+	}
+	written, err := io.Copy(file, io.LimitReader(resp.Body, maxAttachmentSize+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > maxAttachmentSize {
+		err = fmt.Errorf("attachment exceeds %d bytes", maxAttachmentSize)
+	}
+	if err != nil {
+		os.Remove(filePath)
+		return "", err
+	}
+
+	return filePath, nil
+}
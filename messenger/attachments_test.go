@@ -0,0 +1,117 @@
+package messenger
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/qew21/fb-messenger/config"
+	"github.com/qew21/fb-messenger/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachmentKindAndPrompt(t *testing.T) {
+	sticker := webhook.Attachment{Type: "image", Payload: webhook.AttachmentPayload{URL: "https://example.com/s.png", StickerID: 369239263222822}}
+	assert.Equal(t, webhook.AttachmentSticker, sticker.Kind())
+	_, ok := assistantPrompt(sticker)
+	assert.False(t, ok)
+
+	location := webhook.Attachment{Type: "location", Payload: webhook.AttachmentPayload{Coordinates: &webhook.Coordinates{Lat: 31.2304, Long: 121.4737}}}
+	prompt, ok := assistantPrompt(location)
+	assert.True(t, ok)
+	assert.Contains(t, prompt, "latitude 31.230400, longitude 121.473700")
+
+	link := webhook.Attachment{Type: "fallback", Title: "Our store", URL: "https://example.com/store"}
+	prompt, ok = assistantPrompt(link)
+	assert.True(t, ok)
+	assert.Equal(t, "I am sharing this link: Our store https://example.com/store", prompt)
+}
+
+func TestAttachmentPolicy(t *testing.T) {
+	processor := newTestProcessor(t, &config.AppConfig{AttachmentPolicy: map[string]string{"file": AttachmentDownload}}, true)
+	assert.Equal(t, AttachmentDownload, processor.attachmentPolicy("file"))
+	assert.Equal(t, AttachmentAcknowledge, processor.attachmentPolicy("video"))
+
+	_, err := NewProcessor(&config.AppConfig{AttachmentPolicy: map[string]string{"file": "downlaod"}}, true)
+	assert.ErrorContains(t, err, `unknown attachment policy "downlaod" for file`)
+}
+
+// useAttachmentServer lets downloads reach the TLS test server as if it
+// were the Facebook CDN.
+func useAttachmentServer(t *testing.T, server *httptest.Server) {
+	client, hosts := attachmentClient, attachmentHosts
+	attachmentClient = server.Client()
+	attachmentClient.CheckRedirect = checkAttachmentRedirect
+	attachmentHosts = []string{"127.0.0.1"}
+	t.Cleanup(func() { attachmentClient, attachmentHosts = client, hosts })
+}
+
+func TestProcessorDownloadsAttachments(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("%PDF-1.4"))
+	}))
+	defer server.Close()
+	useAttachmentServer(t, server)
+
+	dir := t.TempDir()
+	processor := newTestProcessor(t, &config.AppConfig{
+		AttachmentPolicy:     map[string]string{"file": AttachmentDownload},
+		AttachmentStorageDir: dir,
+	}, true)
+
+	update := loadMock(t, "image.json")
+	update.Entry[0].Messaging[0].Message.Attachments[0] = webhook.Attachment{
+		Type:    "file",
+		Payload: webhook.AttachmentPayload{URL: server.URL + "/invoice.pdf?token=abc"},
+	}
+	require.NoError(t, processor.ProcessMessage(update))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "Ukz9Pxgdh_0.pdf"))
+	require.NoError(t, err)
+	assert.Equal(t, "%PDF-1.4", string(data))
+}
+
+func TestDownloadAttachmentErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/redirect", http.RedirectHandler("https://169.254.169.254/latest/meta-data", http.StatusFound))
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(maxAttachmentSize+1))
+	})
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+	useAttachmentServer(t, server)
+
+	_, err := DownloadAttachment(server.URL+"/missing.png", t.TempDir(), "mid")
+	assert.ErrorContains(t, err, "status code 404")
+	_, err = DownloadAttachment("", t.TempDir(), "mid")
+	assert.Error(t, err)
+	_, err = DownloadAttachment(server.URL+"/redirect", t.TempDir(), "mid")
+	assert.ErrorContains(t, err, "not a Facebook CDN")
+	_, err = DownloadAttachment(server.URL+"/large", t.TempDir(), "mid")
+	assert.ErrorContains(t, err, "exceeds")
+}
+
+func TestCheckAttachmentURL(t *testing.T) {
+	for rawURL, allowed := range map[string]bool{
+		"https://scontent.xx.fbcdn.net/v/t1.png?oh=1": true,
+		"https://cdn.fbsbx.com/v/t59/invoice.pdf":     true,
+		"http://scontent.xx.fbcdn.net/v/t1.png":       false,
+		"https://fbcdn.net.example.com/t1.png":        false,
+		"https://127.0.0.1:8080/admin":                false,
+		"file:///etc/passwd":                          false,
+	} {
+		parsed, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		assert.Equal(t, allowed, checkAttachmentURL(parsed) == nil, rawURL)
+	}
+}
+
+func TestProcessorAcknowledgesAttachments(t *testing.T) {
+	processor := newTestProcessor(t, &config.AppConfig{}, true)
+	assert.NoError(t, processor.ProcessMessage(loadMock(t, "image.json")))
+}
@@ -0,0 +1,86 @@
+package messenger
+
+import (
+	"fmt"
+
+	"github.com/qew21/fb-messenger/config"
+)
+
+// Ways of answering a comment, configured per sentiment in
+// COMMENT_REPLY_MODES.
+const (
+	ReplyPublic  = "public"
+	ReplyPrivate = "private"
+	ReplyBoth    = "both"
+	ReplyNone    = "none"
+)
+
+// DefaultCommentReplyMode applies to sentiments without a configured mode.
+// A private reply keeps the conversation out of the public thread.
+const DefaultCommentReplyMode = ReplyPrivate
+
+type CommentPayload struct {
+	Message string `json:"message"`
+}
+
+// SendComment publishes text as a reply to a comment or post.
+func SendComment(objectID string, text string, appConfig *config.AppConfig) error {
+	if text == "" {
+		return invalidMessage("comment has no message")
+	}
+	return postGraph(objectID+"/comments", CommentPayload{Message: text}, objectID, appConfig)
+}
+
+// SendPrivateReply answers a comment in Messenger. The page may send one
+// private reply per comment, within seven days of the comment.
+func SendPrivateReply(commentID string, message *Message, appConfig *config.AppConfig) error {
+	if err := message.Validate(); err != nil {
+		return err
+	}
+
+	payload := Payload{
+		Recipient:     Recipient{CommentID: commentID},
+		Message:       *message,
+		MessagingType: "RESPONSE",
+	}
+	return postMessages(payload, commentID, appConfig)
+}
+
+func (p *Processor) commentReplyMode(sentiment string) string {
+	if mode, ok := p.appConfig.CommentReplyModes[sentiment]; ok {
+		return mode
+	}
+	return DefaultCommentReplyMode
+}
+
+// replyToComment answers a comment publicly, privately or both, depending on
+// the mode configured for its sentiment.
+func (p *Processor) replyToComment(commentID string, sentiment string, text string) error {
+	if p.testMode {
+		return nil
+	}
+
+	mode := p.commentReplyMode(sentiment)
+	switch mode {
+	case ReplyNone:
+		return nil
+	case ReplyPublic, ReplyPrivate, ReplyBoth:
+	default:
+		return fmt.Errorf("unknown reply mode %q for %s comments", mode, sentiment)
+	}
+
+	// With both modes a failed public reply does not stop the private one.
+	var err error
+	if mode == ReplyPublic || mode == ReplyBoth {
+		err = SendComment(commentID, text, p.appConfig)
+		p.keepDeadLetter(err)
+	}
+	if mode == ReplyPrivate || mode == ReplyBoth {
+		privateErr := SendPrivateReply(commentID, NewTextMessage(text), p.appConfig)
+		p.keepDeadLetter(privateErr)
+		if err == nil {
+			err = privateErr
+		}
+	}
+	return err
+}
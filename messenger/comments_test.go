@@ -0,0 +1,73 @@
+package messenger
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/qew21/fb-messenger/fakegraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplyToCommentModes(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+
+	processor := newTestProcessor(t, newTestConfig(server), false)
+	reply := "We're so glad to hear that! Could you share more about what you enjoyed?"
+
+	// Without a configured mode the reply is private.
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "ratings.json")))
+	assert.Empty(t, server.Comments())
+	messages := server.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "44444444_444444444", messages[0].CommentID)
+	assert.Equal(t, reply, messages[0].Text)
+
+	server.Reset()
+	processor.appConfig.CommentReplyModes = map[string]string{"positive": ReplyPublic}
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "ratings.json")))
+	assert.Empty(t, server.Messages())
+	comments := server.Comments()
+	require.Len(t, comments, 1)
+	assert.Equal(t, "44444444_444444444", comments[0].ObjectID)
+	assert.Equal(t, reply, comments[0].Message)
+	assert.Equal(t, "/v19.0/44444444_444444444/comments", server.Requests()[0].Path)
+
+	server.Reset()
+	processor.appConfig.CommentReplyModes["positive"] = ReplyBoth
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "ratings.json")))
+	assert.Len(t, server.Comments(), 1)
+	assert.Len(t, server.Messages(), 1)
+
+	server.Reset()
+	processor.appConfig.CommentReplyModes["positive"] = ReplyNone
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "ratings.json")))
+	assert.Empty(t, server.Requests())
+
+	processor.appConfig.CommentReplyModes["positive"] = "loud"
+	assert.Error(t, processor.ProcessMessage(loadMock(t, "ratings.json")))
+}
+
+func TestReplayCommentDeadLetter(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+
+	processor := newTestProcessor(t, newTestConfig(server), false)
+	processor.appConfig.CommentReplyModes = map[string]string{"positive": ReplyPublic}
+	processor.DeadLetters = NewFileDeadLetterStore(filepath.Join(t.TempDir(), "deadletters.json"))
+
+	server.FailNext(1, http.StatusBadRequest, fakegraph.CodeInvalidParam, 0)
+	assert.Error(t, processor.ProcessMessage(loadMock(t, "ratings.json")))
+	letters, err := processor.DeadLetters.List()
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, "44444444_444444444/comments", letters[0].Endpoint)
+
+	results, err := ReplayDeadLetters(processor.DeadLetters, nil, processor.appConfig)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Delivered)
+	assert.Len(t, server.Comments(), 1)
+}
@@ -0,0 +1,281 @@
+package messenger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qew21/fb-messenger/config"
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is an outbound message that could not be delivered, kept so it
+// can be replayed once the cause is fixed.
+type DeadLetter struct {
+	ID        string          `json:"id"`
+	Recipient string          `json:"recipient"`
+	Endpoint  string          `json:"endpoint,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	Error     string          `json:"error"`
+	Code      int             `json:"code,omitempty"`
+	Subcode   int             `json:"subcode,omitempty"`
+	Attempts  int             `json:"attempts"`
+	Replays   int             `json:"replays"`
+	FailedAt  time.Time       `json:"failed_at"`
+}
+
+type DeadLetterStore interface {
+	Add(letter DeadLetter) (DeadLetter, error)
+	Update(letter DeadLetter) error
+	List() ([]DeadLetter, error)
+	Get(id string) (DeadLetter, error)
+	Remove(id string) error
+}
+
+// FileDeadLetterStore keeps dead letters in a JSON file. The file is re-read
+// on every call so the replay command and a running server see each other's
+// changes, and changes hold a lock on a ".lock" file next to it so neither
+// overwrites the other's.
+type FileDeadLetterStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileDeadLetterStore(path string) *FileDeadLetterStore {
+	return &FileDeadLetterStore{path: path}
+}
+
+func (s *FileDeadLetterStore) Add(letter DeadLetter) (DeadLetter, error) {
+	if letter.ID == "" {
+		letter.ID = newDeadLetterID()
+	}
+	if letter.FailedAt.IsZero() {
+		letter.FailedAt = time.Now()
+	}
+
+	err := s.modify(func(letters map[string]DeadLetter) error {
+		letters[letter.ID] = letter
+		return nil
+	})
+	return letter, err
+}
+
+func (s *FileDeadLetterStore) Update(letter DeadLetter) error {
+	return s.modify(func(letters map[string]DeadLetter) error {
+		if _, ok := letters[letter.ID]; !ok {
+			return fmt.Errorf("%w: %s", ErrDeadLetterNotFound, letter.ID)
+		}
+		letters[letter.ID] = letter
+		return nil
+	})
+}
+
+func (s *FileDeadLetterStore) List() ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letters, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]DeadLetter, 0, len(letters))
+	for _, letter := range letters {
+		list = append(list, letter)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].FailedAt.Before(list[j].FailedAt)
+	})
+	return list, nil
+}
+
+func (s *FileDeadLetterStore) Get(id string) (DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letters, err := s.load()
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	letter, ok := letters[id]
+	if !ok {
+		return DeadLetter{}, fmt.Errorf("%w: %s", ErrDeadLetterNotFound, id)
+	}
+	return letter, nil
+}
+
+func (s *FileDeadLetterStore) Remove(id string) error {
+	return s.modify(func(letters map[string]DeadLetter) error {
+		if _, ok := letters[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrDeadLetterNotFound, id)
+		}
+		delete(letters, id)
+		return nil
+	})
+}
+
+func (s *FileDeadLetterStore) modify(change func(letters map[string]DeadLetter) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create dead letter directory: %w", err)
+	}
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	letters, err := s.load()
+	if err != nil {
+		return err
+	}
+	if err := change(letters); err != nil {
+		return err
+	}
+	return s.save(letters)
+}
+
+func (s *FileDeadLetterStore) load() (map[string]DeadLetter, error) {
+	letters := make(map[string]DeadLetter)
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return letters, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letters: %w", err)
+	}
+	if len(data) == 0 {
+		return letters, nil
+	}
+
+	var list []DeadLetter
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode dead letters: %w", err)
+	}
+	for _, letter := range list {
+		letters[letter.ID] = letter
+	}
+	return letters, nil
+}
+
+func (s *FileDeadLetterStore) save(letters map[string]DeadLetter) error {
+	list := make([]DeadLetter, 0, len(letters))
+	for _, letter := range letters {
+		list = append(list, letter)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].FailedAt.Before(list[j].FailedAt)
+	})
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letters: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write dead letters: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace dead letters: %w", err)
+	}
+	return nil
+}
+
+func newDeadLetterID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(suffix))
+}
+
+// NewDeadLetter records a failed send returned by Send or SendMessage.
+func NewDeadLetter(sendErr *SendError) DeadLetter {
+	letter := DeadLetter{
+		Recipient: sendErr.Recipient,
+		Endpoint:  sendErr.Endpoint,
+		Payload:   sendErr.Payload,
+		Error:     sendErr.Err.Error(),
+		Attempts:  sendErr.Attempts,
+	}
+	var graphErr *GraphError
+	if errors.As(sendErr.Err, &graphErr) {
+		letter.Code = graphErr.Code
+		letter.Subcode = graphErr.Subcode
+	}
+	return letter
+}
+
+type ReplayResult struct {
+	ID        string `json:"id"`
+	Recipient string `json:"recipient,omitempty"`
+	Delivered bool   `json:"delivered"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ReplayDeadLetters resends the given dead letters, or all of them when ids
+// is empty. Delivered letters are removed from the store; the others keep
+// their latest error.
+func ReplayDeadLetters(store DeadLetterStore, ids []string, appConfig *config.AppConfig) ([]ReplayResult, error) {
+	var letters []DeadLetter
+	if len(ids) == 0 {
+		var err error
+		letters, err = store.List()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]ReplayResult, 0, len(ids)+len(letters))
+	for _, id := range ids {
+		letter, err := store.Get(id)
+		if err != nil {
+			results = append(results, ReplayResult{ID: id, Error: err.Error()})
+			continue
+		}
+		letters = append(letters, letter)
+	}
+
+	for _, letter := range letters {
+		result := ReplayResult{ID: letter.ID, Recipient: letter.Recipient}
+		// Letters stored before endpoints were recorded are all messages.
+		endpoint := letter.Endpoint
+		if endpoint == "" {
+			endpoint = messagesEndpoint(appConfig)
+		}
+		err := postGraph(endpoint, letter.Payload, letter.Recipient, appConfig)
+		if err == nil {
+			result.Delivered = true
+			err = store.Remove(letter.ID)
+		} else {
+			updated := NewDeadLetter(asSendError(err, letter))
+			updated.ID = letter.ID
+			updated.FailedAt = time.Now()
+			updated.Attempts += letter.Attempts
+			updated.Replays = letter.Replays + 1
+			result.Error = updated.Error
+			err = store.Update(updated)
+		}
+		if err != nil && result.Error == "" {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func asSendError(err error, letter DeadLetter) *SendError {
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		return sendErr
+	}
+	return &SendError{Recipient: letter.Recipient, Endpoint: letter.Endpoint, Payload: letter.Payload, Attempts: 1, Err: err}
+}
@@ -0,0 +1,120 @@
+package messenger
+
+import (
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/qew21/fb-messenger/fakegraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileDeadLetterStore(t *testing.T) {
+	store := NewFileDeadLetterStore(filepath.Join(t.TempDir(), "deadletters.json"))
+
+	letters, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+
+	first, err := store.Add(DeadLetter{Recipient: "1", Payload: []byte(`{"recipient":{"id":"1"}}`), Error: "blocked"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, first.ID)
+	second, err := store.Add(DeadLetter{Recipient: "2", Payload: []byte(`{"recipient":{"id":"2"}}`)})
+	require.NoError(t, err)
+
+	// A second store on the same file sees the same letters.
+	other := NewFileDeadLetterStore(store.path)
+	letters, err = other.List()
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	assert.Equal(t, first.ID, letters[0].ID)
+	assert.JSONEq(t, `{"recipient":{"id":"1"}}`, string(letters[0].Payload))
+
+	require.NoError(t, other.Remove(second.ID))
+	_, err = store.Get(second.ID)
+	assert.ErrorIs(t, err, ErrDeadLetterNotFound)
+	assert.ErrorIs(t, store.Remove(second.ID), ErrDeadLetterNotFound)
+}
+
+func TestFileDeadLetterStoreConcurrentWriters(t *testing.T) {
+	// Stores on the same file stand in for the server and the replay command,
+	// which do not share a mutex.
+	path := filepath.Join(t.TempDir(), "deadletters.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(store *FileDeadLetterStore) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := store.Add(DeadLetter{Recipient: "1"})
+				assert.NoError(t, err)
+			}
+		}(NewFileDeadLetterStore(path))
+	}
+	wg.Wait()
+
+	letters, err := NewFileDeadLetterStore(path).List()
+	require.NoError(t, err)
+	assert.Len(t, letters, 40)
+}
+
+func TestProcessorStoresDeadLetters(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+
+	processor := newTestProcessor(t, newTestConfig(server), false)
+	processor.DeadLetters = NewFileDeadLetterStore(filepath.Join(t.TempDir(), "deadletters.json"))
+
+	server.FailNext(1, http.StatusBadRequest, fakegraph.CodeOutsideWindow, fakegraph.SubcodeOutsideWindow)
+	assert.Error(t, processor.ProcessMessage(loadMock(t, "ratings.json")))
+
+	letters, err := processor.DeadLetters.List()
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, "44444444_444444444", letters[0].Recipient)
+	assert.Equal(t, fakegraph.CodeOutsideWindow, letters[0].Code)
+	assert.Equal(t, fakegraph.SubcodeOutsideWindow, letters[0].Subcode)
+	assert.Equal(t, 1, letters[0].Attempts)
+	assert.Contains(t, string(letters[0].Payload), "We're so glad to hear that!")
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+	appConfig := newTestConfig(server)
+
+	store := NewFileDeadLetterStore(filepath.Join(t.TempDir(), "deadletters.json"))
+	delivered, err := store.Add(DeadLetter{Recipient: "1", Payload: []byte(`{"recipient":{"id":"1"},"message":{"text":"first"},"messaging_type":"RESPONSE"}`), Attempts: 4})
+	require.NoError(t, err)
+	failed, err := store.Add(DeadLetter{Recipient: "2", Payload: []byte(`{"recipient":{"id":"2"},"message":{"text":"second"},"messaging_type":"RESPONSE"}`), Attempts: 4})
+	require.NoError(t, err)
+
+	server.Fail(fakegraph.ErrorResponse{})
+	results, err := ReplayDeadLetters(store, []string{failed.ID, delivered.ID, "missing"}, appConfig)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, "missing", results[0].ID)
+	assert.Contains(t, results[0].Error, "not found")
+	assert.False(t, results[1].Delivered)
+	assert.True(t, results[2].Delivered)
+
+	messages := server.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "first", messages[0].Text)
+
+	letters, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, failed.ID, letters[0].ID)
+	assert.Equal(t, 1, letters[0].Replays)
+	assert.Equal(t, 5, letters[0].Attempts)
+
+	results, err = ReplayDeadLetters(store, nil, appConfig)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Delivered)
+	letters, _ = store.List()
+	assert.Empty(t, letters)
+}
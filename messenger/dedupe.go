@@ -0,0 +1,212 @@
+package messenger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DedupeStore remembers the events that have already been accepted so that
+// redelivered webhooks are acknowledged without being processed again.
+type DedupeStore interface {
+	Seen(key string) (bool, error)
+	Add(key string, ttl time.Duration) error
+}
+
+// MemoryDedupeStore keeps keys in memory until their TTL expires.
+type MemoryDedupeStore struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	adds    int
+	now     func() time.Time
+}
+
+func NewMemoryDedupeStore() *MemoryDedupeStore {
+	return &MemoryDedupeStore{
+		expires: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryDedupeStore) Seen(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires, ok := s.expires[key]
+	if !ok {
+		return false, nil
+	}
+	if !s.now().Before(expires) {
+		delete(s.expires, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *MemoryDedupeStore) Add(key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.add(key, s.now().Add(ttl))
+	return nil
+}
+
+func (s *MemoryDedupeStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.expires)
+}
+
+func (s *MemoryDedupeStore) add(key string, expires time.Time) {
+	s.expires[key] = expires
+	s.adds++
+	if s.adds%1000 == 0 {
+		s.sweep()
+	}
+}
+
+func (s *MemoryDedupeStore) sweep() {
+	now := s.now()
+	for key, expires := range s.expires {
+		if !now.Before(expires) {
+			delete(s.expires, key)
+		}
+	}
+}
+
+type dedupeRecord struct {
+	Key     string    `json:"key"`
+	Expires time.Time `json:"expires"`
+}
+
+// dedupeCompactLines is the smallest file that is compacted while the store
+// is open; larger files are compacted once at least half their lines are
+// expired or overwritten keys.
+const dedupeCompactLines = 1000
+
+// FileDedupeStore persists keys as JSON lines so that redeliveries are still
+// recognised after a restart. Expired keys are dropped when the file is
+// opened and whenever it has grown to twice the number of live keys.
+type FileDedupeStore struct {
+	*MemoryDedupeStore
+	path  string
+	file  *os.File
+	lines int
+}
+
+func OpenFileDedupeStore(path string) (*FileDedupeStore, error) {
+	memory := NewMemoryDedupeStore()
+	if err := loadDedupeRecords(path, memory); err != nil {
+		return nil, err
+	}
+
+	store := &FileDedupeStore{MemoryDedupeStore: memory, path: path}
+	if err := store.compact(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *FileDedupeStore) Add(key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := dedupeRecord{Key: key, Expires: s.now().Add(ttl)}
+	s.add(record.Key, record.Expires)
+	if err := json.NewEncoder(s.file).Encode(record); err != nil {
+		return fmt.Errorf("failed to persist dedupe key %s: %w", key, err)
+	}
+	s.lines++
+
+	if s.lines >= dedupeCompactLines && s.lines >= 2*len(s.expires) {
+		s.sweep()
+		if err := s.compact(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close compacts the file when it holds expired or overwritten keys, so the
+// next start loads no more than it needs, and closes it.
+func (s *FileDedupeStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep()
+	if s.lines > len(s.expires) {
+		if err := s.compact(); err != nil {
+			s.file.Close()
+			return err
+		}
+	}
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close dedupe store: %w", err)
+	}
+	return nil
+}
+
+// compact rewrites the file with only the keys in memory and reopens it for
+// appending.
+func (s *FileDedupeStore) compact() error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create dedupe store: %w", err)
+	}
+	encoder := json.NewEncoder(tmp)
+	for key, expires := range s.expires {
+		if err := encoder.Encode(dedupeRecord{Key: key, Expires: expires}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write dedupe store: %w", err)
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write dedupe store: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace dedupe store: %w", err)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open dedupe store: %w", err)
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = file
+	s.lines = len(s.expires)
+	return nil
+}
+
+func loadDedupeRecords(path string, memory *MemoryDedupeStore) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open dedupe store: %w", err)
+	}
+	defer file.Close()
+
+	now := memory.now()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record dedupeRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A partially written last line is expected after a crash.
+			continue
+		}
+		if now.Before(record.Expires) {
+			memory.expires[record.Key] = record.Expires
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read dedupe store: %w", err)
+	}
+	return nil
+}
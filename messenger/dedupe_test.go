@@ -0,0 +1,161 @@
+package messenger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryDedupeStoreExpires(t *testing.T) {
+	now := time.Unix(1710061072, 0)
+	store := NewMemoryDedupeStore()
+	store.now = func() time.Time { return now }
+
+	seen, err := store.Seen("mid:1")
+	require.NoError(t, err)
+	assert.False(t, seen)
+
+	require.NoError(t, store.Add("mid:1", time.Minute))
+	seen, _ = store.Seen("mid:1")
+	assert.True(t, seen)
+
+	now = now.Add(time.Minute)
+	seen, _ = store.Seen("mid:1")
+	assert.False(t, seen)
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestFileDedupeStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedupe.jsonl")
+	store, err := OpenFileDedupeStore(path)
+	require.NoError(t, err)
+	require.NoError(t, store.Add("mid:1", time.Hour))
+	require.NoError(t, store.Add("mid:2", -time.Second))
+	require.NoError(t, store.Close())
+
+	store, err = OpenFileDedupeStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	seen, _ := store.Seen("mid:1")
+	assert.True(t, seen)
+	seen, _ = store.Seen("mid:2")
+	assert.False(t, seen)
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestFileDedupeStoreCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedupe.jsonl")
+	store, err := OpenFileDedupeStore(path)
+	require.NoError(t, err)
+	for i := 0; i < 3*dedupeCompactLines; i++ {
+		require.NoError(t, store.Add(fmt.Sprintf("mid:%d", i%10), time.Hour))
+	}
+	require.NoError(t, store.Close())
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Less(t, bytes.Count(data, []byte("\n")), dedupeCompactLines)
+
+	store, err = OpenFileDedupeStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+	assert.Equal(t, 10, store.Len())
+}
+
+func TestDispatcherClosesDedupeStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedupe.jsonl")
+	store, err := OpenFileDedupeStore(path)
+	require.NoError(t, err)
+	require.NoError(t, store.Add("mid:expired", -time.Second))
+
+	d := NewDispatcher(2, 10, func(event Event) error { return nil })
+	d.SetDedupeStore(store, time.Hour)
+	require.NoError(t, d.Submit(loadMock(t, "batch.json")))
+	require.NoError(t, d.Close(context.Background()))
+	require.NoError(t, d.Close(context.Background()))
+
+	// The file was compacted to the processed keys and closed.
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 4, bytes.Count(data, []byte("\n")))
+	assert.Error(t, store.Add("mid:late", time.Hour))
+}
+
+func TestEventDedupeKey(t *testing.T) {
+	assert.Equal(t, "mid:Ukz9Pxgdg", Events(loadMock(t, "message.json"))[0].DedupeKey())
+	assert.Equal(t, "feed:44444444_444444444::status:add:1710061072", Events(loadMock(t, "feed.json"))[0].DedupeKey())
+	assert.Equal(t, "ratings:444444:add:1710061084", Events(loadMock(t, "ratings.json"))[0].DedupeKey())
+}
+
+func TestDispatcherSkipsDuplicates(t *testing.T) {
+	processed := make(chan string, 10)
+	d := NewDispatcher(2, 10, func(event Event) error {
+		processed <- event.DedupeKey()
+		return nil
+	})
+	d.SetDedupeStore(NewMemoryDedupeStore(), time.Hour)
+
+	require.NoError(t, d.Submit(loadMock(t, "message.json")))
+	require.NoError(t, d.Submit(loadMock(t, "message.json")))
+	require.NoError(t, d.Submit(loadMock(t, "feed.json")))
+	require.NoError(t, d.Close(context.Background()))
+	close(processed)
+
+	var keys []string
+	for key := range processed {
+		keys = append(keys, key)
+	}
+	assert.Len(t, keys, 2)
+	assert.Equal(t, uint64(1), d.Stats().Duplicates)
+}
+
+func TestDispatcherDoesNotRememberRejectedBatch(t *testing.T) {
+	store := NewMemoryDedupeStore()
+	release := make(chan struct{})
+	d := NewDispatcher(1, 1, func(event Event) error {
+		<-release
+		return nil
+	})
+	d.SetDedupeStore(store, time.Hour)
+
+	assert.ErrorIs(t, d.Submit(loadMock(t, "batch.json")), ErrQueueFull)
+	close(release)
+	require.NoError(t, d.Close(context.Background()))
+
+	d = NewDispatcher(1, 10, func(event Event) error { return nil })
+	d.SetDedupeStore(store, time.Hour)
+	require.NoError(t, d.Submit(loadMock(t, "batch.json")))
+	require.NoError(t, d.Close(context.Background()))
+	assert.Equal(t, uint64(4), d.Stats().Processed)
+}
+
+func TestDispatcherRemembersEventsOnceProcessed(t *testing.T) {
+	store := NewMemoryDedupeStore()
+	release := make(chan struct{})
+	d := NewDispatcher(1, 10, func(event Event) error {
+		<-release
+		return nil
+	})
+	d.SetDedupeStore(store, time.Hour)
+
+	require.NoError(t, d.Submit(loadMock(t, "message.json")))
+	seen, _ := store.Seen("mid:Ukz9Pxgdg")
+	assert.False(t, seen, "a queued event is not recorded until it is processed")
+	// A redelivery while the event is still queued is a duplicate.
+	require.NoError(t, d.Submit(loadMock(t, "message.json")))
+	assert.Equal(t, uint64(1), d.Stats().Duplicates)
+
+	close(release)
+	require.NoError(t, d.Close(context.Background()))
+	seen, _ = store.Seen("mid:Ukz9Pxgdg")
+	assert.True(t, seen)
+	assert.Equal(t, uint64(1), d.Stats().Processed)
+}
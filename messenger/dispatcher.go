@@ -0,0 +1,330 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qew21/fb-messenger/webhook"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrQueueFull        = errors.New("event queue is full")
+	ErrDispatcherClosed = errors.New("dispatcher is closed")
+)
+
+// ProcessFunc handles a single event on a worker goroutine.
+type ProcessFunc func(event Event) error
+
+// PrefetchFunc prepares for the events of an update before any of them is
+// processed. It should give up once ctx is done.
+type PrefetchFunc func(ctx context.Context, events []Event)
+
+// DispatcherStats is a snapshot of the dispatcher's queue, exposed so that
+// backpressure is visible before Facebook starts disabling the webhook.
+type DispatcherStats struct {
+	Workers     int    `json:"workers"`
+	QueueSize   int    `json:"queue_size"`
+	QueueDepth  int    `json:"queue_depth"`
+	ShardDepths []int  `json:"shard_depths"`
+	HighWater   int64  `json:"high_water"`
+	Enqueued    uint64 `json:"enqueued"`
+	Duplicates  uint64 `json:"duplicates"`
+	Rejected    uint64 `json:"rejected"`
+	Processed   uint64 `json:"processed"`
+	Failed      uint64 `json:"failed"`
+}
+
+// Dispatcher decouples webhook acknowledgement from event processing. Events
+// are sharded by sender onto bounded per-worker queues, so a sender's events
+// are handled one at a time and in delivery order while different senders
+// are processed in parallel.
+type Dispatcher struct {
+	process         ProcessFunc
+	prefetch        PrefetchFunc
+	prefetchTimeout time.Duration
+	shards          []chan queuedEvent
+	wg              sync.WaitGroup
+
+	mu        sync.Mutex
+	closed    bool
+	dedupe    DedupeStore
+	dedupeTTL time.Duration
+	// queued holds the dedupe keys of accepted events that have not been
+	// processed yet; they are only written to dedupe once processed.
+	queued map[string]bool
+
+	highWater  int64
+	enqueued   uint64
+	duplicates uint64
+	rejected   uint64
+	processed  uint64
+	failed     uint64
+}
+
+// queuedEvent is an event waiting for a worker, with the update it came in.
+type queuedEvent struct {
+	event Event
+	batch *prefetchBatch
+}
+
+// prefetchBatch holds the events of one update, prefetched by whichever
+// worker gets to one of them first.
+type prefetchBatch struct {
+	once   sync.Once
+	events []Event
+}
+
+// NewDispatcher starts workers, each with its own queue of queueSize events,
+// so a burst from one busy sender cannot starve the others of queue space.
+func NewDispatcher(workers int, queueSize int, process ProcessFunc) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	d := &Dispatcher{
+		process: process,
+		shards:  make([]chan queuedEvent, workers),
+	}
+	d.wg.Add(workers)
+	for i := range d.shards {
+		d.shards[i] = make(chan queuedEvent, queueSize)
+		go d.work(d.shards[i])
+	}
+	return d
+}
+
+// SetDedupeStore makes Submit skip events whose DedupeKey is still queued or
+// was processed within the last ttl. Keys are recorded after processing, so
+// an event lost in a crash is processed when Facebook redelivers it. It must
+// be called before the first Submit.
+func (d *Dispatcher) SetDedupeStore(store DedupeStore, ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dedupe = store
+	d.dedupeTTL = ttl
+	d.queued = make(map[string]bool)
+}
+
+// SetPrefetch makes the workers pass the accepted events of every update to
+// prefetch, such as to score their texts in one batch, before processing any
+// of them. Prefetching runs once per update, for at most timeout, and a
+// worker that needs it meanwhile waits. It must be called before the first
+// Submit.
+func (d *Dispatcher) SetPrefetch(prefetch PrefetchFunc, timeout time.Duration) {
+	d.prefetch = prefetch
+	d.prefetchTimeout = timeout
+}
+
+// Submit enqueues every event of the update without waiting for them to be
+// processed. A batch is accepted or rejected as a whole so that a retried
+// delivery does not repeat the part that was already queued. Events that were
+// already accepted are dropped silently.
+func (d *Dispatcher) Submit(update *webhook.Update) error {
+	if update.Object != webhook.ObjectPage {
+		return fmt.Errorf("unknown object type in payload: %q", update.Object)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return ErrDispatcherClosed
+	}
+
+	events, keys := d.filterDuplicates(Events(update))
+	shards := make([]int, len(events))
+	counts := make([]int, len(d.shards))
+	for i, event := range events {
+		shards[i] = d.shardFor(event)
+		counts[shards[i]]++
+	}
+	for shard, count := range counts {
+		if cap(d.shards[shard])-len(d.shards[shard]) < count {
+			atomic.AddUint64(&d.rejected, uint64(len(events)))
+			return ErrQueueFull
+		}
+	}
+	var batch *prefetchBatch
+	if d.prefetch != nil && len(events) > 0 {
+		batch = &prefetchBatch{events: events}
+	}
+	for i, event := range events {
+		d.shards[shards[i]] <- queuedEvent{event: event, batch: batch}
+	}
+	atomic.AddUint64(&d.enqueued, uint64(len(events)))
+	for _, key := range keys {
+		d.queued[key] = true
+	}
+
+	depth := int64(d.depth())
+	if depth > atomic.LoadInt64(&d.highWater) {
+		atomic.StoreInt64(&d.highWater, depth)
+	}
+	return nil
+}
+
+// Close stops accepting events and waits for the queued ones to be processed,
+// or for ctx to expire. Once they are, the dedupe store is closed if it is an
+// io.Closer.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, shard := range d.shards {
+			close(shard)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return d.closeDedupe()
+	case <-ctx.Done():
+		return fmt.Errorf("dispatcher did not drain %d event(s): %w", d.depth(), ctx.Err())
+	}
+}
+
+func (d *Dispatcher) closeDedupe() error {
+	d.mu.Lock()
+	store := d.dedupe
+	d.dedupe = nil
+	d.mu.Unlock()
+	if closer, ok := store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (d *Dispatcher) Stats() DispatcherStats {
+	stats := DispatcherStats{
+		Workers:     len(d.shards),
+		ShardDepths: make([]int, len(d.shards)),
+		HighWater:   atomic.LoadInt64(&d.highWater),
+		Enqueued:    atomic.LoadUint64(&d.enqueued),
+		Duplicates:  atomic.LoadUint64(&d.duplicates),
+		Rejected:    atomic.LoadUint64(&d.rejected),
+		Processed:   atomic.LoadUint64(&d.processed),
+		Failed:      atomic.LoadUint64(&d.failed),
+	}
+	for i, shard := range d.shards {
+		stats.QueueSize += cap(shard)
+		stats.ShardDepths[i] = len(shard)
+		stats.QueueDepth += len(shard)
+	}
+	return stats
+}
+
+func (d *Dispatcher) filterDuplicates(events []Event) ([]Event, []string) {
+	if d.dedupe == nil {
+		return events, nil
+	}
+
+	var fresh []Event
+	var keys []string
+	inBatch := make(map[string]bool)
+	for _, event := range events {
+		key := event.DedupeKey()
+		if key == "" {
+			fresh = append(fresh, event)
+			continue
+		}
+
+		seen, err := d.dedupe.Seen(key)
+		if err != nil {
+			// Processing twice is better than not at all.
+			log.Warn().Err(err).Str("key", key).Msg("Failed to check dedupe key")
+		}
+		if seen || inBatch[key] || d.queued[key] {
+			atomic.AddUint64(&d.duplicates, 1)
+			continue
+		}
+		inBatch[key] = true
+		fresh = append(fresh, event)
+		keys = append(keys, key)
+	}
+	return fresh, keys
+}
+
+func (d *Dispatcher) depth() int {
+	depth := 0
+	for _, shard := range d.shards {
+		depth += len(shard)
+	}
+	return depth
+}
+
+func (d *Dispatcher) shardFor(event Event) int {
+	h := fnv.New32a()
+	h.Write([]byte(event.SenderID()))
+	return int(h.Sum32() % uint32(len(d.shards)))
+}
+
+func (d *Dispatcher) work(queue <-chan queuedEvent) {
+	defer d.wg.Done()
+	for queued := range queue {
+		if queued.batch != nil {
+			queued.batch.once.Do(func() { d.prefetchBatch(queued.batch.events) })
+		}
+		event := queued.event
+		if err := d.handle(event); err != nil {
+			atomic.AddUint64(&d.failed, 1)
+			log.Warn().Err(err).Str("event", event.String()).Msg("Event processing failed")
+		}
+		d.remember(event)
+		atomic.AddUint64(&d.processed, 1)
+	}
+}
+
+// remember records a processed event's dedupe key. Failed events are
+// recorded too: the webhook was acknowledged, so a redelivery is a duplicate.
+func (d *Dispatcher) remember(event Event) {
+	key := event.DedupeKey()
+	if d.dedupe == nil || key == "" {
+		return
+	}
+	if err := d.dedupe.Add(key, d.dedupeTTL); err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("Failed to record dedupe key")
+	}
+	d.mu.Lock()
+	delete(d.queued, key)
+	d.mu.Unlock()
+}
+
+func (d *Dispatcher) prefetchBatch(events []Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warn().Interface("panic", r).Int("events", len(events)).Msg("Prefetch failed")
+		}
+	}()
+	ctx := context.Background()
+	if d.prefetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.prefetchTimeout)
+		defer cancel()
+	}
+	d.prefetch(ctx, events)
+}
+
+func (d *Dispatcher) handle(event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while processing event: %v", r)
+		}
+	}()
+	return d.process(event)
+}
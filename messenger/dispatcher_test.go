@@ -0,0 +1,209 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qew21/fb-messenger/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatcherProcessesEveryEvent(t *testing.T) {
+	var mu sync.Mutex
+	var mids []string
+	d := NewDispatcher(4, 40, func(event Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		mids = append(mids, event.Messaging.Message.Mid)
+		if event.Messaging.Message.Mid == "mid.2" {
+			return errors.New("no text")
+		}
+		return nil
+	})
+
+	require.NoError(t, d.Submit(loadMock(t, "batch.json")))
+	require.NoError(t, d.Close(context.Background()))
+
+	assert.ElementsMatch(t, []string{"mid.1", "mid.2", "mid.3", "mid.4"}, mids)
+	stats := d.Stats()
+	assert.Equal(t, uint64(4), stats.Enqueued)
+	assert.Equal(t, uint64(4), stats.Processed)
+	assert.Equal(t, uint64(1), stats.Failed)
+	assert.GreaterOrEqual(t, stats.HighWater, int64(1))
+}
+
+func TestDispatcherRejectsWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	d := NewDispatcher(1, 3, func(event Event) error {
+		<-release
+		return nil
+	})
+
+	err := d.Submit(loadMock(t, "batch.json"))
+	assert.ErrorIs(t, err, ErrQueueFull)
+	assert.Equal(t, uint64(4), d.Stats().Rejected)
+	assert.Equal(t, 0, d.Stats().QueueDepth)
+
+	require.NoError(t, d.Submit(loadMock(t, "message.json")))
+	close(release)
+	require.NoError(t, d.Close(context.Background()))
+	assert.ErrorIs(t, d.Submit(loadMock(t, "message.json")), ErrDispatcherClosed)
+}
+
+func TestDispatcherQueueSizeIsPerShard(t *testing.T) {
+	release := make(chan struct{})
+	d := NewDispatcher(4, 4, func(event Event) error {
+		<-release
+		return nil
+	})
+	defer d.Close(context.Background())
+	defer close(release)
+	assert.Equal(t, 16, d.Stats().QueueSize)
+
+	// Every event of one busy sender lands on the same shard.
+	update := loadMock(t, "batch.json")
+	for i := range update.Entry[0].Messaging {
+		update.Entry[0].Messaging[i].Sender.ID = "busy"
+	}
+	require.NoError(t, d.Submit(update))
+	assert.ErrorIs(t, d.Submit(update), ErrQueueFull)
+	// The other shards still have room.
+	require.NoError(t, d.Submit(loadMock(t, "message.json")))
+}
+
+func TestDispatcherCloseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	d := NewDispatcher(1, 5, func(event Event) error {
+		<-release
+		return nil
+	})
+	require.NoError(t, d.Submit(loadMock(t, "batch.json")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, d.Close(ctx), context.DeadlineExceeded)
+}
+
+func TestDispatcherRecoversPanics(t *testing.T) {
+	d := NewDispatcher(1, 5, func(event Event) error {
+		panic("unexpected event")
+	})
+	require.NoError(t, d.Submit(loadMock(t, "message.json")))
+	require.NoError(t, d.Close(context.Background()))
+	assert.Equal(t, uint64(1), d.Stats().Failed)
+}
+
+func TestDispatcherPrefetchesOnWorkers(t *testing.T) {
+	release := make(chan struct{})
+	var prefetched [][]Event
+	var deadline bool
+	var processed int32
+	d := NewDispatcher(4, 10, func(event Event) error {
+		atomic.AddInt32(&processed, 1)
+		return nil
+	})
+	d.SetDedupeStore(NewMemoryDedupeStore(), time.Hour)
+	d.SetPrefetch(func(ctx context.Context, events []Event) {
+		_, deadline = ctx.Deadline()
+		<-release
+		prefetched = append(prefetched, events)
+	}, time.Minute)
+
+	// Submit does not wait for the prefetch, and no event is processed
+	// before it finished.
+	require.NoError(t, d.Submit(loadMock(t, "batch.json")))
+	time.Sleep(10 * time.Millisecond)
+	assert.EqualValues(t, 0, atomic.LoadInt32(&processed))
+	close(release)
+
+	// A redelivery is not prefetched again.
+	require.NoError(t, d.Submit(loadMock(t, "batch.json")))
+	require.NoError(t, d.Close(context.Background()))
+	assert.EqualValues(t, 4, processed)
+	require.Len(t, prefetched, 1)
+	assert.Len(t, prefetched[0], 4)
+	assert.True(t, deadline)
+}
+
+func TestDispatcherRejectsUnknownObject(t *testing.T) {
+	d := NewDispatcher(1, 5, func(event Event) error { return nil })
+	defer d.Close(context.Background())
+	err := d.Submit(&webhook.Update{Object: "user"})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrQueueFull)
+}
+
+func TestDispatcherKeepsPerSenderOrder(t *testing.T) {
+	const senders = 20
+	const messagesPerSender = 50
+
+	var mu sync.Mutex
+	received := make(map[string][]int)
+	var running, maxRunning int32
+	d := NewDispatcher(8, 8*senders*messagesPerSender, func(event Event) error {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+
+		var seq int
+		fmt.Sscanf(event.Messaging.Message.Text, "%d", &seq)
+		mu.Lock()
+		received[event.Messaging.Sender.ID] = append(received[event.Messaging.Sender.ID], seq)
+		mu.Unlock()
+		return nil
+	})
+
+	// Interleave senders across many small batches, as Facebook does under load.
+	for i := 0; i < messagesPerSender; i++ {
+		update := &webhook.Update{Object: webhook.ObjectPage}
+		for s := 0; s < senders; s++ {
+			update.Entry = append(update.Entry, webhook.Entry{
+				ID: "0",
+				Messaging: []webhook.MessagingEvent{{
+					Sender:  webhook.User{ID: fmt.Sprintf("psid-%d", s)},
+					Message: &webhook.Message{Mid: fmt.Sprintf("mid.%d.%d", s, i), Text: fmt.Sprintf("%d", i)},
+				}},
+			})
+		}
+		require.NoError(t, d.Submit(update))
+	}
+	require.NoError(t, d.Close(context.Background()))
+
+	require.Len(t, received, senders)
+	for sender, seqs := range received {
+		require.Len(t, seqs, messagesPerSender, sender)
+		for i, seq := range seqs {
+			require.Equal(t, i, seq, "sender %s received message %d out of order", sender, seq)
+		}
+	}
+	assert.Greater(t, atomic.LoadInt32(&maxRunning), int32(1), "different senders should run in parallel")
+}
+
+func TestDispatcherShardsBySender(t *testing.T) {
+	d := NewDispatcher(8, 80, func(event Event) error { return nil })
+	defer d.Close(context.Background())
+
+	update := loadMock(t, "batch.json")
+	events := Events(update)
+	assert.Equal(t, d.shardFor(events[0]), d.shardFor(events[2]))
+	assert.Equal(t, d.shardFor(events[1]), d.shardFor(events[3]))
+
+	feed := Events(loadMock(t, "feed.json"))[0]
+	assert.Equal(t, "1067280970047460", feed.SenderID())
+	ratings := Events(loadMock(t, "ratings.json"))[0]
+	assert.Equal(t, "444444", ratings.SenderID())
+}
@@ -0,0 +1,123 @@
+package messenger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Permanent Send API failures callers may want to tell apart with errors.Is.
+var (
+	ErrUserUnavailable = errors.New("user cannot receive messages")
+	ErrOutsideWindow   = errors.New("outside the 24 hour messaging window")
+	ErrAccessToken     = errors.New("page access token is invalid")
+)
+
+// GraphError is the error object returned by the Graph API.
+type GraphError struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"message"`
+	Type       string `json:"type"`
+	Code       int    `json:"code"`
+	Subcode    int    `json:"error_subcode"`
+	FBTraceID  string `json:"fbtrace_id"`
+}
+
+func (e *GraphError) Error() string {
+	return fmt.Sprintf("graph api error %d/%d (%s, fbtrace_id %s): %s", e.Code, e.Subcode, e.Type, e.FBTraceID, e.Message)
+}
+
+// Transient reports whether the request may succeed if retried: unknown and
+// temporary API errors, the app and page rate limits, and 5xx responses.
+// Other failures are permanent and retrying them risks sending twice.
+func (e *GraphError) Transient() bool {
+	switch e.Code {
+	case 1, 2, 4, 613:
+		return true
+	}
+	return e.StatusCode >= 500
+}
+
+// RateLimited reports whether the call was rejected for exceeding an
+// application, page or Send API rate limit.
+func (e *GraphError) RateLimited() bool {
+	switch e.Code {
+	case 4, 17, 32, 613:
+		return true
+	}
+	return false
+}
+
+func (e *GraphError) Is(target error) bool {
+	switch target {
+	case ErrUserUnavailable:
+		return e.Code == 551 || (e.Code == 200 && e.Subcode == 1545041) || (e.Code == 10 && e.Subcode == 2018108)
+	case ErrOutsideWindow:
+		return e.Code == 10 && (e.Subcode == 2018278 || e.Subcode == 2018065)
+	case ErrAccessToken:
+		return e.Code == 190
+	}
+	return false
+}
+
+// parseGraphError builds a GraphError from a non-200 response body. Bodies
+// that are not Graph error objects are kept as the message.
+func parseGraphError(statusCode int, body []byte) *GraphError {
+	var envelope struct {
+		Error *GraphError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error == nil {
+		return &GraphError{StatusCode: statusCode, Message: string(body)}
+	}
+	envelope.Error.StatusCode = statusCode
+	return envelope.Error
+}
+
+// SendError is returned when a send failed after all attempts. Payload is
+// the request body, kept so the send can be replayed.
+type SendError struct {
+	Recipient string
+	// Endpoint is the Graph API path the payload was posted to.
+	Endpoint string
+	Payload  json.RawMessage
+	Attempts int
+	Err      error
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("Failed to send message to %s after %d attempt(s): %v", e.Recipient, e.Attempts, e.Err)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+func isTransient(err error) bool {
+	var graphErr *GraphError
+	if errors.As(err, &graphErr) {
+		return graphErr.Transient()
+	}
+	// Only retry when the request never left, such as a refused connection;
+	// after a response timeout Facebook may have delivered the message.
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// backoff returns a jittered delay of up to base*2^(attempt-1), capped at max.
+func backoff(base time.Duration, max time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
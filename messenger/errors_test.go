@@ -0,0 +1,152 @@
+package messenger
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qew21/fb-messenger/config"
+	"github.com/qew21/fb-messenger/fakegraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGraphError(t *testing.T) {
+	graphErr := parseGraphError(http.StatusBadRequest, []byte(`{"error":{"message":"(#551) This person isn't available right now.","type":"OAuthException","code":551,"error_subcode":1545041,"fbtrace_id":"A1b2C3"}}`))
+	assert.Equal(t, 551, graphErr.Code)
+	assert.Equal(t, 1545041, graphErr.Subcode)
+	assert.Equal(t, "A1b2C3", graphErr.FBTraceID)
+	assert.True(t, errors.Is(graphErr, ErrUserUnavailable))
+	assert.False(t, graphErr.Transient())
+
+	graphErr = parseGraphError(http.StatusBadGateway, []byte(`<html>Bad Gateway</html>`))
+	assert.Equal(t, "<html>Bad Gateway</html>", graphErr.Message)
+	assert.True(t, graphErr.Transient())
+}
+
+func TestGraphErrorClassification(t *testing.T) {
+	testCases := []struct {
+		name      string
+		err       *GraphError
+		transient bool
+		target    error
+	}{
+		{"TooManyCalls", &GraphError{StatusCode: 400, Code: 4}, true, nil},
+		{"PageRateLimit", &GraphError{StatusCode: 400, Code: 613}, true, nil},
+		{"ServerError", &GraphError{StatusCode: 503, Code: 2}, true, nil},
+		{"UserRequestLimit", &GraphError{StatusCode: 400, Code: 17}, false, nil},
+		{"UnknownServerError", &GraphError{StatusCode: 500}, true, nil},
+		{"ServiceUnavailable", &GraphError{StatusCode: 503, Message: "<html>Service Unavailable</html>"}, true, nil},
+		{"GatewayTimeout", &GraphError{StatusCode: 504}, true, nil},
+		{"OutsideWindow", &GraphError{StatusCode: 400, Code: 10, Subcode: 2018278}, false, ErrOutsideWindow},
+		{"Blocked", &GraphError{StatusCode: 400, Code: 551}, false, ErrUserUnavailable},
+		{"Token", &GraphError{StatusCode: 400, Code: 190}, false, ErrAccessToken},
+		{"InvalidParam", &GraphError{StatusCode: 400, Code: 100}, false, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.transient, tc.err.Transient())
+			if tc.target != nil {
+				assert.ErrorIs(t, tc.err, tc.target)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt <= 10; attempt++ {
+		delay := backoff(100*time.Millisecond, time.Second, attempt)
+		assert.LessOrEqual(t, delay, time.Second)
+		assert.GreaterOrEqual(t, delay, 50*time.Millisecond)
+	}
+	assert.Equal(t, time.Duration(0), backoff(0, 0, 3))
+}
+
+func TestSendRetriesTransientErrors(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+	appConfig := newTestConfig(server)
+	appConfig.SendMaxAttempts = 4
+	appConfig.SendRetryBaseDelay = time.Millisecond
+	appConfig.SendRetryMaxDelay = 5 * time.Millisecond
+
+	server.FailNext(1, http.StatusBadRequest, fakegraph.CodeRateLimit, 0)
+	server.FailNext(1, http.StatusInternalServerError, 2, 0)
+	server.FailNext(1, http.StatusBadGateway, 0, 0)
+	require.NoError(t, SendMessage("44444444", "hello", appConfig))
+	assert.Len(t, server.Requests(), 4)
+	assert.Len(t, server.Messages(), 1)
+
+	server.Reset()
+	server.FailNext(5, http.StatusBadRequest, fakegraph.CodeTooManyCalls, 0)
+	err := SendMessage("44444444", "hello", appConfig)
+	var sendErr *SendError
+	require.True(t, errors.As(err, &sendErr))
+	assert.Equal(t, 4, sendErr.Attempts)
+	assert.Equal(t, "44444444", sendErr.Recipient)
+	assert.Len(t, server.Requests(), 4)
+}
+
+func TestSendDoesNotRetryPermanentErrors(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+	appConfig := newTestConfig(server)
+	appConfig.SendMaxAttempts = 4
+	appConfig.SendRetryBaseDelay = time.Millisecond
+
+	server.FailNext(1, http.StatusBadRequest, fakegraph.CodeOutsideWindow, fakegraph.SubcodeOutsideWindow)
+	err := SendMessage("44444444", "hello", appConfig)
+	assert.ErrorIs(t, err, ErrOutsideWindow)
+	var graphErr *GraphError
+	require.True(t, errors.As(err, &graphErr))
+	assert.Equal(t, "AfakeTraceID", graphErr.FBTraceID)
+	assert.Len(t, server.Requests(), 1)
+}
+
+func TestSendRetriesOnlyUnsentRequests(t *testing.T) {
+	// The connection is dropped after the request arrived, as when a response
+	// times out: Facebook may have delivered the message already.
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		conn.Close()
+	}))
+	defer server.Close()
+	appConfig := &config.AppConfig{GraphAPIURL: server.URL, APIVersion: "v19.0", PageID: "0", SendMaxAttempts: 4, SendRetryBaseDelay: time.Millisecond}
+
+	var sendErr *SendError
+	require.True(t, errors.As(SendMessage("44444444", "hello", appConfig), &sendErr))
+	assert.Equal(t, 1, sendErr.Attempts)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&requests))
+
+	// A refused connection never reached Facebook and is retried.
+	server.Close()
+	require.True(t, errors.As(SendMessage("44444444", "hello", appConfig), &sendErr))
+	assert.Equal(t, 4, sendErr.Attempts)
+}
+
+func TestProcessorReturnsSendErrors(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+	appConfig := newTestConfig(server)
+	appConfig.SenderActions = false
+
+	processor := newTestProcessor(t, appConfig, false)
+	server.FailNext(1, http.StatusBadRequest, fakegraph.CodeUserUnavailable, 0)
+	err := processor.ProcessMessage(loadMock(t, "ratings.json"))
+	var batchErr BatchError
+	require.True(t, errors.As(err, &batchErr))
+	assert.ErrorIs(t, batchErr[0], ErrUserUnavailable)
+
+	processor.assistant = func(userID string, message string, key string) (string, error) {
+		return "", errors.New("quota exceeded")
+	}
+	err = processor.ProcessMessage(loadMock(t, "message.json"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "quota exceeded")
+}
@@ -0,0 +1,173 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/qew21/fb-messenger/analysis"
+	"github.com/qew21/fb-messenger/webhook"
+)
+
+// Event is a single messaging event or change, together with the page entry
+// it was delivered in.
+type Event struct {
+	PageID    string
+	Time      int64
+	Messaging *webhook.MessagingEvent
+	Change    *webhook.Change
+	// Language is the language detected in the event's text, or "".
+	Language string
+}
+
+// Events flattens every entry of an update into events, keeping the order in
+// which Facebook delivered them.
+func Events(update *webhook.Update) []Event {
+	var events []Event
+	for _, entry := range update.Entry {
+		for i := range entry.Messaging {
+			events = append(events, Event{PageID: entry.ID, Time: entry.Time, Messaging: &entry.Messaging[i]})
+		}
+		for i := range entry.Changes {
+			events = append(events, Event{PageID: entry.ID, Time: entry.Time, Change: &entry.Changes[i]})
+		}
+	}
+	for i := range events {
+		events[i].Language = analysis.DetectLanguage(events[i].Text())
+	}
+	return events
+}
+
+// Text is what the user wrote: a message, postback title, post or comment,
+// or review.
+func (e Event) Text() string {
+	switch {
+	case e.Messaging != nil:
+		switch {
+		case e.Messaging.Message != nil:
+			return e.Messaging.Message.Text
+		case e.Messaging.Postback != nil:
+			return e.Messaging.Postback.Title
+		}
+	case e.Change != nil:
+		switch e.Change.Field {
+		case webhook.FieldFeed:
+			if value, err := e.Change.Feed(); err == nil {
+				return value.Message
+			}
+		case webhook.FieldRatings:
+			if value, err := e.Change.Ratings(); err == nil {
+				return value.ReviewText
+			}
+		}
+	}
+	return ""
+}
+
+// SenderID identifies who caused the event: the PSID for messaging events and
+// the author for changes. Changes without a known author fall back to the page.
+func (e Event) SenderID() string {
+	switch {
+	case e.Messaging != nil:
+		return e.Messaging.Sender.ID
+	case e.Change != nil:
+		switch e.Change.Field {
+		case webhook.FieldFeed:
+			if value, err := e.Change.Feed(); err == nil && value.From.ID != "" {
+				return value.From.ID
+			}
+		case webhook.FieldRatings:
+			if value, err := e.Change.Ratings(); err == nil && value.ReviewerID != "" {
+				return value.ReviewerID
+			}
+		}
+	}
+	return e.PageID
+}
+
+// DedupeKey identifies the event across redeliveries. Events without a stable
+// identifier return an empty key and are never deduplicated.
+func (e Event) DedupeKey() string {
+	switch {
+	case e.Messaging != nil:
+		event := e.Messaging
+		switch {
+		case event.Message != nil && event.Message.Mid != "":
+			return "mid:" + event.Message.Mid
+		case event.Postback != nil && event.Postback.Mid != "":
+			return "mid:" + event.Postback.Mid
+		case event.Postback != nil:
+			return fmt.Sprintf("postback:%s:%d:%s", event.Sender.ID, event.Timestamp, event.Postback.Payload)
+		}
+	case e.Change != nil:
+		switch e.Change.Field {
+		case webhook.FieldFeed:
+			if value, err := e.Change.Feed(); err == nil {
+				return fmt.Sprintf("feed:%s:%s:%s:%s:%d", value.PostID, value.CommentID, value.Item, value.Verb, value.CreatedTime)
+			}
+		case webhook.FieldRatings:
+			if value, err := e.Change.Ratings(); err == nil {
+				return fmt.Sprintf("ratings:%s:%s:%d", value.OpenGraphStoryID, value.Verb, value.CreatedTime)
+			}
+		case webhook.FieldMention:
+			if value, err := e.Change.Mention(); err == nil {
+				return fmt.Sprintf("mention:%s:%s:%s", value.PostID, value.CommentID, value.Verb)
+			}
+		}
+	}
+	return ""
+}
+
+// Validate reports events that cannot be processed, such as a message
+// without a sender or a change without a field.
+func (e Event) Validate() error {
+	switch {
+	case e.Messaging != nil:
+		if e.Messaging.Sender.ID == "" {
+			return errors.New("messaging event has no sender")
+		}
+	case e.Change != nil:
+		if e.Change.Field == "" {
+			return errors.New("change has no field")
+		}
+	}
+	return nil
+}
+
+func (e Event) String() string {
+	switch {
+	case e.Messaging != nil:
+		return fmt.Sprintf("messaging event from %s", e.Messaging.Sender.ID)
+	case e.Change != nil:
+		return fmt.Sprintf("%s change on page %s", e.Change.Field, e.PageID)
+	default:
+		return "empty event"
+	}
+}
+
+// EventError records the failure of one event in a batch.
+type EventError struct {
+	Index int
+	Event Event
+	Err   error
+}
+
+func (e *EventError) Error() string {
+	return fmt.Sprintf("event %d (%s): %v", e.Index, e.Event, e.Err)
+}
+
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError collects the events of an update that failed to process. The
+// remaining events of the batch are still handled.
+type BatchError []*EventError
+
+func (b BatchError) Error() string {
+	messages := make([]string, len(b))
+	for i, err := range b {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d event(s) failed: %s", len(b), strings.Join(messages, "; "))
+}
@@ -0,0 +1,196 @@
+package messenger
+
+import (
+	"github.com/qew21/fb-messenger/analysis"
+	"github.com/qew21/fb-messenger/webhook"
+	"github.com/rs/zerolog/log"
+)
+
+// FeedEvent is a feed change passed to a FeedHandler.
+type FeedEvent struct {
+	Change   webhook.Change
+	Value    webhook.FeedValue
+	Language string
+}
+
+// ObjectID is the comment the change is about, or else the post.
+func (e FeedEvent) ObjectID() string {
+	if e.Value.CommentID != "" {
+		return e.Value.CommentID
+	}
+	return e.Value.PostID
+}
+
+type FeedHandler func(event FeedEvent) error
+
+type feedRoute struct {
+	item string
+	verb string
+}
+
+// HandleFeed registers handler for feed changes of item with verb. An empty
+// verb matches every verb of the item without a handler of its own.
+func (p *Processor) HandleFeed(item string, verb string, handler FeedHandler) {
+	p.feedHandlers[feedRoute{item, verb}] = handler
+}
+
+func (p *Processor) registerFeedHandlers() {
+	p.HandleFeed(webhook.FeedItemComment, webhook.VerbAdd, p.handleCommentAdded)
+	for _, verb := range []string{webhook.VerbAdd, webhook.VerbEdit, webhook.VerbRemove} {
+		p.HandleFeed(webhook.FeedItemReaction, verb, p.handleReaction)
+	}
+
+	for _, item := range []string{
+		webhook.FeedItemComment,
+		webhook.FeedItemStatus,
+		webhook.FeedItemPost,
+		webhook.FeedItemPhoto,
+		webhook.FeedItemVideo,
+		webhook.FeedItemShare,
+	} {
+		if item != webhook.FeedItemComment {
+			p.HandleFeed(item, webhook.VerbAdd, p.handlePostAdded)
+		}
+		p.HandleFeed(item, webhook.VerbEdited, p.handleFeedEdited)
+		p.HandleFeed(item, webhook.VerbRemove, p.handleFeedRemoved)
+		p.HandleFeed(item, webhook.VerbHide, p.handleFeedHidden)
+		p.HandleFeed(item, webhook.VerbUnhide, p.handleFeedUnhidden)
+	}
+}
+
+func (p *Processor) processFeed(change webhook.Change, language string) error {
+	value, err := change.Feed()
+	if err != nil {
+		return err
+	}
+
+	handler, ok := p.feedHandlers[feedRoute{value.Item, value.Verb}]
+	if !ok {
+		handler, ok = p.feedHandlers[feedRoute{value.Item, ""}]
+	}
+	if !ok {
+		log.Debug().Str("item", value.Item).Str("verb", value.Verb).Str("post_id", value.PostID).Msg("Ignoring feed change")
+		return nil
+	}
+	return handler(FeedEvent{Change: change, Value: *value, Language: language})
+}
+
+// handleCommentAdded records the sentiment of a new comment and answers it.
+// The page's own comments, including its public replies, are neither scored
+// nor answered so they do not skew the page's sentiment.
+func (p *Processor) handleCommentAdded(event FeedEvent) error {
+	if p.fromPage(event) {
+		return nil
+	}
+	result, err := p.recordFeedSentiment(event)
+	if err != nil {
+		return err
+	}
+	return p.replyBySentiment(event.Value.CommentID, result, event.Language)
+}
+
+// handlePostAdded records the sentiment of a new post without replying.
+func (p *Processor) handlePostAdded(event FeedEvent) error {
+	if p.fromPage(event) {
+		return nil
+	}
+	_, err := p.recordFeedSentiment(event)
+	return err
+}
+
+// handleFeedEdited re-scores an edited post or comment. The reply sent for
+// the original is not repeated.
+func (p *Processor) handleFeedEdited(event FeedEvent) error {
+	if p.fromPage(event) {
+		return nil
+	}
+	if _, err := p.recordFeedSentiment(event); err != nil {
+		return err
+	}
+	p.Sentiments.Update(event.ObjectID(), func(record *SentimentRecord) {
+		record.Edited = true
+	})
+	return nil
+}
+
+func (p *Processor) handleFeedRemoved(event FeedEvent) error {
+	p.Sentiments.Update(event.ObjectID(), func(record *SentimentRecord) {
+		p.describe(record, event)
+		record.Removed = true
+	})
+	return nil
+}
+
+func (p *Processor) handleFeedHidden(event FeedEvent) error {
+	p.Sentiments.Update(event.ObjectID(), func(record *SentimentRecord) {
+		p.describe(record, event)
+		record.Hidden = true
+	})
+	return nil
+}
+
+func (p *Processor) handleFeedUnhidden(event FeedEvent) error {
+	p.Sentiments.Update(event.ObjectID(), func(record *SentimentRecord) {
+		p.describe(record, event)
+		record.Hidden = false
+	})
+	return nil
+}
+
+func (p *Processor) handleReaction(event FeedEvent) error {
+	userID := event.Value.From.ID
+	if userID == "" {
+		return nil
+	}
+	if event.Value.Verb == webhook.VerbRemove {
+		p.Reactions.Remove(event.ObjectID(), userID)
+		return nil
+	}
+	if event.Value.ReactionType != "" {
+		p.Reactions.Set(event.ObjectID(), userID, event.Value.ReactionType)
+	}
+	return nil
+}
+
+func (p *Processor) recordFeedSentiment(event FeedEvent) (analysis.PredictionResult, error) {
+	var result analysis.PredictionResult
+	if event.Value.Message != "" {
+		var err error
+		result, _, err = p.analyzeChange(event.Change)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	p.Sentiments.Update(event.ObjectID(), func(record *SentimentRecord) {
+		p.describe(record, event)
+		record.Sentiment = result.Prediction
+		record.Probability = result.Probability
+		record.Emotions = labelNames(result.Emotions)
+		record.Aspects = labelNames(result.Aspects)
+	})
+	return result, nil
+}
+
+func labelNames(labels []analysis.Label) []string {
+	var names []string
+	for _, label := range labels {
+		names = append(names, label.Name)
+	}
+	return names
+}
+
+func (p *Processor) describe(record *SentimentRecord, event FeedEvent) {
+	record.PostID = event.Value.PostID
+	record.Item = event.Value.Item
+	if event.Language != "" {
+		record.Language = event.Language
+	}
+	if event.Value.From.ID != "" {
+		record.AuthorID = event.Value.From.ID
+	}
+}
+
+func (p *Processor) fromPage(event FeedEvent) bool {
+	return p.appConfig.PageID != "" && event.Value.From.ID == p.appConfig.PageID
+}
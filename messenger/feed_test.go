@@ -0,0 +1,271 @@
+package messenger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qew21/fb-messenger/analysis"
+	"github.com/qew21/fb-messenger/config"
+	"github.com/qew21/fb-messenger/fakegraph"
+	"github.com/qew21/fb-messenger/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func feedUpdate(t *testing.T, value webhook.FeedValue) *webhook.Update {
+	data, err := json.Marshal(value)
+	require.NoError(t, err)
+	return &webhook.Update{
+		Object: webhook.ObjectPage,
+		Entry: []webhook.Entry{{
+			ID:      "0",
+			Changes: []webhook.Change{{Field: webhook.FieldFeed, Value: data}},
+		}},
+	}
+}
+
+func TestFeedReactionsAreTallied(t *testing.T) {
+	processor := newTestProcessor(t, &config.AppConfig{}, true)
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "feed_reaction.json")))
+	assert.Equal(t, map[string]int{"love": 1}, processor.Reactions.Object("44444444_444444444"))
+
+	reaction := webhook.FeedValue{Item: webhook.FeedItemReaction, PostID: "44444444_444444444", From: webhook.User{ID: "555555"}}
+	reaction.Verb, reaction.ReactionType = webhook.VerbEdit, "haha"
+	require.NoError(t, processor.ProcessMessage(feedUpdate(t, reaction)))
+	reaction.From.ID = "666666"
+	reaction.Verb, reaction.ReactionType = webhook.VerbAdd, "like"
+	require.NoError(t, processor.ProcessMessage(feedUpdate(t, reaction)))
+	reaction.CommentID = "444444444_55555555"
+	require.NoError(t, processor.ProcessMessage(feedUpdate(t, reaction)))
+
+	assert.Equal(t, map[string]int{"haha": 1, "like": 1}, processor.Reactions.Object("44444444_444444444"))
+	assert.Equal(t, map[string]int{"like": 1}, processor.Reactions.Object("444444444_55555555"))
+	assert.Equal(t, map[string]int{"haha": 1, "like": 2}, processor.Reactions.Totals())
+
+	reaction.CommentID = ""
+	reaction.Verb, reaction.ReactionType = webhook.VerbRemove, ""
+	require.NoError(t, processor.ProcessMessage(feedUpdate(t, reaction)))
+	assert.Equal(t, map[string]int{"haha": 1}, processor.Reactions.Object("44444444_444444444"))
+}
+
+func TestReactionTallyForgetsOldestObjects(t *testing.T) {
+	tally := NewReactionTally()
+	for i := 0; i <= maxReactionObjects; i++ {
+		tally.Set(fmt.Sprintf("post_%d", i), "555555", "like")
+	}
+	assert.Empty(t, tally.Object("post_0"))
+	assert.Equal(t, map[string]int{"like": 1}, tally.Object("post_1"))
+	assert.Equal(t, map[string]int{"like": maxReactionObjects}, tally.Totals())
+
+	// Changing a reaction does not make the object any newer.
+	tally.Set("post_1", "555555", "love")
+	tally.Set("post_new", "555555", "like")
+	assert.Empty(t, tally.Object("post_1"))
+}
+
+func TestFeedRemovalsUpdateSentiment(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+	processor := newTestProcessor(t, newTestConfig(server), false)
+
+	processor.Sentiments.Set(SentimentRecord{ID: "444444444_55555555", PostID: "44444444_444444444", Item: webhook.FeedItemComment, Sentiment: "negative"})
+	processor.Sentiments.Set(SentimentRecord{ID: "44444444_444444444", Item: webhook.FeedItemStatus, Sentiment: "positive"})
+	assert.Equal(t, map[string]int{"negative": 1, "positive": 1}, processor.Sentiments.Counts())
+
+	// A removed comment has no message and must not be analyzed or answered.
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "feed_comment_remove.json")))
+	record, ok := processor.Sentiments.Get("444444444_55555555")
+	require.True(t, ok)
+	assert.True(t, record.Removed)
+	assert.Equal(t, "negative", record.Sentiment)
+	assert.Equal(t, "555555", record.AuthorID)
+
+	require.NoError(t, processor.ProcessMessage(feedUpdate(t, webhook.FeedValue{Item: webhook.FeedItemStatus, Verb: webhook.VerbHide, PostID: "44444444_444444444"})))
+	record, _ = processor.Sentiments.Get("44444444_444444444")
+	assert.True(t, record.Hidden)
+	assert.Empty(t, processor.Sentiments.Counts())
+
+	require.NoError(t, processor.ProcessMessage(feedUpdate(t, webhook.FeedValue{Item: webhook.FeedItemStatus, Verb: webhook.VerbUnhide, PostID: "44444444_444444444"})))
+	record, _ = processor.Sentiments.Get("44444444_444444444")
+	assert.False(t, record.Hidden)
+	assert.Equal(t, map[string]int{"positive": 1}, processor.Sentiments.Counts())
+	assert.Empty(t, server.Requests())
+}
+
+func TestFeedUnknownItemsAreIgnored(t *testing.T) {
+	processor := newTestProcessor(t, &config.AppConfig{}, true)
+	require.NoError(t, processor.ProcessMessage(feedUpdate(t, webhook.FeedValue{Item: "event", Verb: webhook.VerbAdd, PostID: "1"})))
+
+	called := false
+	processor.HandleFeed("event", "", func(event FeedEvent) error {
+		called = true
+		assert.Equal(t, "1", event.ObjectID())
+		return nil
+	})
+	require.NoError(t, processor.ProcessMessage(feedUpdate(t, webhook.FeedValue{Item: "event", Verb: webhook.VerbAdd, PostID: "1"})))
+	assert.True(t, called)
+}
+
+func newPredictor(t *testing.T, result *analysis.PredictionResult) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(result)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFeedCommentSentimentThreshold(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+	prediction := &analysis.PredictionResult{Prediction: analysis.Negative, Probability: 0.9}
+	predictor := newPredictor(t, prediction)
+
+	appConfig := newTestConfig(server)
+	appConfig.PredictUrl = predictor.URL
+	appConfig.SentimentThreshold = 0.6
+	processor := newTestProcessor(t, appConfig, false)
+
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "feed_comment.json")))
+	messages := server.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "444444444_55555555", messages[0].CommentID)
+	// The comment complains about a late delivery and a damaged box.
+	assert.Equal(t, aspectReplies[analysis.LanguageEnglish][analysis.AspectShipping], messages[0].Text)
+	record, ok := processor.Sentiments.Get("444444444_55555555")
+	require.True(t, ok)
+	assert.Equal(t, analysis.Negative, record.Sentiment)
+	assert.Equal(t, 0.9, record.Probability)
+	assert.Equal(t, []string{analysis.AspectShipping, analysis.AspectQuality}, record.Aspects)
+	assert.Equal(t, map[string]int{analysis.AspectShipping: 1, analysis.AspectQuality: 1}, processor.Sentiments.AspectCounts())
+
+	// An uncertain prediction is stored as neutral and not answered.
+	server.Reset()
+	prediction.Probability = 0.55
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "feed_comment.json")))
+	assert.Empty(t, server.Requests())
+	record, _ = processor.Sentiments.Get("444444444_55555555")
+	assert.Equal(t, analysis.Neutral, record.Sentiment)
+	assert.Equal(t, 0.55, record.Probability)
+
+	// Edits update the stored sentiment without a second reply.
+	prediction.Probability = 0.95
+	edited := loadMock(t, "feed_comment.json")
+	value, err := edited.Entry[0].Changes[0].Feed()
+	require.NoError(t, err)
+	value.Verb = webhook.VerbEdited
+	require.NoError(t, processor.ProcessMessage(feedUpdate(t, *value)))
+	assert.Empty(t, server.Requests())
+	record, _ = processor.Sentiments.Get("444444444_55555555")
+	assert.Equal(t, analysis.Negative, record.Sentiment)
+	assert.True(t, record.Edited)
+}
+
+func TestFeedSentimentsAreBatched(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+	var batches, singles int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/predict", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&singles, 1)
+		json.NewEncoder(w).Encode(analysis.PredictionResult{Prediction: analysis.Neutral, Probability: 1})
+	})
+	mux.HandleFunc("/batch", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&batches, 1)
+		var request struct{ Texts []string }
+		json.NewDecoder(r.Body).Decode(&request)
+		results := make([]analysis.PredictionResult, len(request.Texts))
+		for i := range results {
+			results[i] = analysis.PredictionResult{Prediction: analysis.Neutral, Probability: 1}
+		}
+		json.NewEncoder(w).Encode(results)
+	})
+	predictor := httptest.NewServer(mux)
+	defer predictor.Close()
+
+	appConfig := newTestConfig(server)
+	appConfig.SentimentAnalyzer = analysis.AnalyzerHTTP
+	appConfig.PredictUrl = predictor.URL + "/predict"
+	appConfig.PredictBatchUrl = predictor.URL + "/batch"
+	appConfig.SentimentCacheSize = 10
+	processor := newTestProcessor(t, appConfig, false)
+
+	update := &webhook.Update{Object: webhook.ObjectPage}
+	for i, message := range []string{"Buy followers now", "buy followers NOW", "When do you open?"} {
+		value := webhook.FeedValue{Item: webhook.FeedItemComment, Verb: webhook.VerbAdd, PostID: "44444444_444444444", Message: message}
+		value.CommentID = fmt.Sprintf("444444444_%d", i)
+		value.From.ID = "555555"
+		update.Entry = append(update.Entry, feedUpdate(t, value).Entry...)
+	}
+	// The whole update is scored before any worker handles a comment.
+	d := NewDispatcher(2, 10, processor.ProcessEvent)
+	d.SetPrefetch(processor.PrefetchSentiments, time.Second)
+	require.NoError(t, d.Submit(update))
+	require.NoError(t, d.Close(context.Background()))
+
+	assert.EqualValues(t, 1, batches)
+	assert.EqualValues(t, 0, singles)
+	assert.Equal(t, uint64(0), d.Stats().Failed)
+	assert.Equal(t, map[string]int{analysis.Neutral: 3}, processor.Sentiments.Counts())
+}
+
+func TestFeedCommentFromPageIsNotAnswered(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+	predictor := newPredictor(t, &analysis.PredictionResult{Prediction: analysis.Positive, Probability: 1})
+
+	appConfig := newTestConfig(server)
+	appConfig.PredictUrl = predictor.URL
+	appConfig.PageID = "555555"
+	processor := newTestProcessor(t, appConfig, false)
+
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "feed_comment.json")))
+	assert.Empty(t, server.Requests())
+	// The page's own replies do not count towards its sentiment or aspects.
+	_, ok := processor.Sentiments.Get("444444444_55555555")
+	assert.False(t, ok)
+	assert.Empty(t, processor.Sentiments.AspectCounts())
+}
+
+func TestFeedCommentReplyLanguage(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+	predictor := newPredictor(t, &analysis.PredictionResult{Prediction: analysis.Negative, Probability: 0.9})
+
+	appConfig := newTestConfig(server)
+	appConfig.PredictUrl = predictor.URL
+	processor := newTestProcessor(t, appConfig, false)
+
+	update := loadMock(t, "feed_comment.json")
+	value, err := update.Entry[0].Changes[0].Feed()
+	require.NoError(t, err)
+	value.Message = "快递太慢了，盒子也坏了"
+	update = feedUpdate(t, *value)
+	assert.Equal(t, analysis.LanguageChinese, Events(update)[0].Language)
+
+	require.NoError(t, processor.ProcessMessage(update))
+	messages := server.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, aspectReplies[analysis.LanguageChinese][analysis.AspectShipping], messages[0].Text)
+	record, _ := processor.Sentiments.Get(value.CommentID)
+	assert.Equal(t, analysis.LanguageChinese, record.Language)
+}
+
+func TestSentimentReply(t *testing.T) {
+	assert.Equal(t, sentimentReplies[analysis.LanguageEnglish][analysis.Positive], sentimentReply("", analysis.Positive))
+	assert.Equal(t, sentimentReplies[analysis.LanguageEnglish][analysis.Negative], sentimentReply("fr", analysis.Negative))
+	assert.Equal(t, sentimentReplies[analysis.LanguageChinese][analysis.Positive], sentimentReply(analysis.LanguageChinese, analysis.Positive))
+	assert.Empty(t, sentimentReply(analysis.LanguageChinese, analysis.Neutral))
+}
+
+func TestAspectReply(t *testing.T) {
+	assert.Equal(t, aspectReplies[analysis.LanguageEnglish][analysis.AspectPrice], aspectReply("", analysis.Negative, analysis.AspectPrice))
+	assert.Equal(t, aspectReplies[analysis.LanguageChinese][analysis.AspectService], aspectReply(analysis.LanguageChinese, analysis.Negative, analysis.AspectService))
+	assert.Equal(t, sentimentReplies[analysis.LanguageEnglish][analysis.Negative], aspectReply(analysis.LanguageEnglish, analysis.Negative, ""))
+	assert.Equal(t, sentimentReplies[analysis.LanguageEnglish][analysis.Positive], aspectReply(analysis.LanguageEnglish, analysis.Positive, analysis.AspectShipping))
+}
@@ -0,0 +1,61 @@
+package messenger
+
+import (
+	"net/url"
+	"unicode/utf8"
+
+	"github.com/qew21/fb-messenger/format"
+)
+
+// sendFormatted sends assistant Markdown as Messenger text. A short reply
+// with a few links is sent as a button template with one URL button per
+// link; anything else is sent as text with the URLs inline.
+func (p *Processor) sendFormatted(psid string, markdown string) error {
+	result := format.Markdown(markdown)
+	if result.Text == "" {
+		return nil
+	}
+
+	if message := linkButtonMessage(result); message != nil {
+		return p.sendMessage(psid, message)
+	}
+	return p.sendReply(psid, result.Text)
+}
+
+func linkButtonMessage(result format.Result) *Message {
+	if len(result.Links) == 0 || len(result.Links) > MaxButtons {
+		return nil
+	}
+	if result.Plain == "" || utf8.RuneCountInString(result.Plain) > MaxButtonTemplateText {
+		return nil
+	}
+
+	buttons := make([]Button, 0, len(result.Links))
+	for _, link := range result.Links {
+		if u, err := url.Parse(link.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil
+		}
+		buttons = append(buttons, URLButton(buttonTitle(link), link.URL))
+	}
+	message := NewButtonTemplate(result.Plain, buttons...)
+	if message.Validate() != nil {
+		return nil
+	}
+	return message
+}
+
+func buttonTitle(link format.Link) string {
+	title := link.Text
+	if title == "" || title == link.URL {
+		if u, err := url.Parse(link.URL); err == nil && u.Host != "" {
+			title = u.Host
+		} else {
+			title = link.URL
+		}
+	}
+	if utf8.RuneCountInString(title) > MaxButtonTitleLength {
+		runes := []rune(title)
+		title = string(runes[:MaxButtonTitleLength-1]) + "…"
+	}
+	return title
+}
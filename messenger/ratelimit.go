@@ -0,0 +1,270 @@
+package messenger
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/qew21/fb-messenger/config"
+	"github.com/rs/zerolog/log"
+)
+
+// rateRecoveryInterval is how long a lowered rate is kept before it is
+// doubled again, unless newer usage headers say otherwise.
+const rateRecoveryInterval = time.Minute
+
+const (
+	AppUsageHeader             = "X-App-Usage"
+	PageUsageHeader            = "X-Page-Usage"
+	BusinessUseCaseUsageHeader = "X-Business-Use-Case-Usage"
+)
+
+// RateLimiter is a token bucket shared by every send to a page. Callers that
+// find the bucket empty wait for their turn instead of being dropped, and the
+// rate is lowered automatically as the Graph API reports rising usage.
+type RateLimiter struct {
+	mu       sync.Mutex
+	baseRate float64
+	rate     float64
+	factor   float64
+	burst    float64
+	tokens   float64
+	// last is when tokens were last refilled; it lies in the future while the
+	// limiter is paused.
+	last time.Time
+	// recoverAt is when a lowered rate is next raised; zero at full rate.
+	recoverAt time.Time
+	now       func() time.Time
+}
+
+func NewRateLimiter(ratePerSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	l := &RateLimiter{
+		baseRate: ratePerSecond,
+		rate:     ratePerSecond,
+		factor:   1,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		now:      time.Now,
+	}
+	l.last = l.now()
+	return l
+}
+
+// Wait blocks until the caller may send or ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	delay := l.reserve()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token, possibly going into debt, and returns how long the
+// caller has to wait for it. Reservations are served in the order made.
+func (l *RateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.refill(now)
+	l.tokens--
+
+	wait := time.Duration(0)
+	if l.last.After(now) {
+		wait = l.last.Sub(now)
+	}
+	if l.tokens < 0 {
+		wait += time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	return wait
+}
+
+// Observe adjusts the rate from the usage headers of a Graph API response.
+func (l *RateLimiter) Observe(header http.Header) {
+	usage, regain := parseUsage(header)
+	if usage < 0 {
+		return
+	}
+
+	switch {
+	case usage >= 100 || regain > 0:
+		if regain <= 0 {
+			regain = time.Minute
+		}
+		l.Pause(regain)
+		l.setFactor(0.1)
+	case usage >= 90:
+		l.setFactor(0.1)
+	case usage >= 75:
+		l.setFactor(0.25)
+	case usage >= 50:
+		l.setFactor(0.5)
+	default:
+		l.setFactor(1)
+	}
+}
+
+// Throttled slows the limiter down after the Graph API rejected a call for
+// exceeding a rate limit. It never raises a rate lowered further by Observe.
+func (l *RateLimiter) Throttled() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.applyFactor(math.Min(l.factor, 0.25))
+}
+
+// Pause stops handing out tokens for d.
+func (l *RateLimiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.refill(now)
+	if until := now.Add(d); until.After(l.last) {
+		log.Warn().Dur("pause", d).Msg("Pausing Send API calls")
+		l.last = until
+		l.tokens = math.Min(l.tokens, 1)
+	}
+}
+
+// Rate returns the current rate in calls per second.
+func (l *RateLimiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+func (l *RateLimiter) setFactor(factor float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.applyFactor(factor)
+}
+
+// applyFactor sets the rate to factor times the base rate. Lowered rates
+// recover step by step, so a throttle is not permanent when later responses
+// carry no usage headers.
+func (l *RateLimiter) applyFactor(factor float64) {
+	now := l.now()
+	l.refill(now)
+	l.factor = factor
+	l.recoverAt = time.Time{}
+	if factor < 1 {
+		l.recoverAt = now.Add(rateRecoveryInterval)
+	}
+	l.setRate(l.baseRate * factor)
+}
+
+func (l *RateLimiter) setRate(rate float64) {
+	if rate != l.rate {
+		log.Info().Float64("rate", rate).Float64("base_rate", l.baseRate).Msg("Adjusting Send API rate")
+		l.rate = rate
+	}
+}
+
+// recover doubles a lowered rate for every rateRecoveryInterval passed.
+func (l *RateLimiter) recover(now time.Time) {
+	if l.recoverAt.IsZero() || now.Before(l.recoverAt) {
+		return
+	}
+	for l.factor < 1 && !now.Before(l.recoverAt) {
+		l.factor = math.Min(1, l.factor*2)
+		l.recoverAt = l.recoverAt.Add(rateRecoveryInterval)
+	}
+	if l.factor >= 1 {
+		l.recoverAt = time.Time{}
+	}
+	l.setRate(l.baseRate * l.factor)
+}
+
+func (l *RateLimiter) refill(now time.Time) {
+	l.recover(now)
+	if !now.After(l.last) {
+		return
+	}
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
+
+type businessUseCaseUsage struct {
+	CallCount                   float64 `json:"call_count"`
+	TotalCPUTime                float64 `json:"total_cputime"`
+	TotalTime                   float64 `json:"total_time"`
+	EstimatedTimeToRegainAccess float64 `json:"estimated_time_to_regain_access"`
+}
+
+// parseUsage returns the highest usage percentage reported by the response
+// headers, or -1 when there are none, and how long until access is regained
+// when the API reports a block.
+func parseUsage(header http.Header) (float64, time.Duration) {
+	usage := -1.0
+	var regain time.Duration
+
+	for _, name := range []string{AppUsageHeader, PageUsageHeader} {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+		var counters map[string]float64
+		if err := json.Unmarshal([]byte(value), &counters); err != nil {
+			continue
+		}
+		for _, counter := range counters {
+			usage = math.Max(usage, counter)
+		}
+	}
+
+	if value := header.Get(BusinessUseCaseUsageHeader); value != "" {
+		var objects map[string][]businessUseCaseUsage
+		if err := json.Unmarshal([]byte(value), &objects); err == nil {
+			for _, entries := range objects {
+				for _, entry := range entries {
+					usage = math.Max(usage, math.Max(entry.CallCount, math.Max(entry.TotalCPUTime, entry.TotalTime)))
+					if d := time.Duration(entry.EstimatedTimeToRegainAccess * float64(time.Minute)); d > regain {
+						regain = d
+					}
+				}
+			}
+		}
+	}
+
+	return usage, regain
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = make(map[string]*RateLimiter)
+)
+
+// rateLimiterFor returns the limiter shared by all sends to the configured
+// page, or nil when rate limiting is disabled.
+func rateLimiterFor(appConfig *config.AppConfig) *RateLimiter {
+	rate := appConfig.SendRateLimit
+	if pageRate, ok := appConfig.PageSendRateLimits[appConfig.PageID]; ok {
+		rate = pageRate
+	}
+	if rate <= 0 {
+		return nil
+	}
+
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	limiter, ok := limiters[appConfig.PageID]
+	if !ok {
+		limiter = NewRateLimiter(rate, appConfig.SendRateBurst)
+		limiters[appConfig.PageID] = limiter
+	}
+	return limiter
+}
@@ -0,0 +1,125 @@
+package messenger
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/qew21/fb-messenger/config"
+	"github.com/qew21/fb-messenger/fakegraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeClockLimiter(rate float64, burst int) (*RateLimiter, *time.Time) {
+	now := time.Unix(1710061072, 0)
+	limiter := NewRateLimiter(rate, burst)
+	limiter.now = func() time.Time { return now }
+	limiter.last = now
+	return limiter, &now
+}
+
+func TestRateLimiterQueuesBeyondBurst(t *testing.T) {
+	limiter, now := newFakeClockLimiter(2, 2)
+
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve())
+	assert.Equal(t, time.Second, limiter.reserve())
+
+	// One second pays back the two reserved tokens.
+	*now = now.Add(time.Second)
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve())
+	*now = now.Add(10 * time.Second)
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+}
+
+func TestRateLimiterObservesUsageHeaders(t *testing.T) {
+	limiter, now := newFakeClockLimiter(10, 1)
+
+	limiter.Observe(http.Header{AppUsageHeader: {`{"call_count":60,"total_time":20,"total_cputime":20}`}})
+	assert.Equal(t, 5.0, limiter.Rate())
+
+	limiter.Observe(http.Header{BusinessUseCaseUsageHeader: {`{"44444444":[{"type":"pages","call_count":80,"total_cputime":10,"total_time":10,"estimated_time_to_regain_access":0}]}`}})
+	assert.Equal(t, 2.5, limiter.Rate())
+
+	limiter.Observe(http.Header{PageUsageHeader: {`{"call_count":10}`}})
+	assert.Equal(t, 10.0, limiter.Rate())
+
+	limiter.Observe(http.Header{BusinessUseCaseUsageHeader: {`{"44444444":[{"type":"pages","call_count":100,"estimated_time_to_regain_access":2}]}`}})
+	assert.Equal(t, 2*time.Minute, limiter.reserve())
+	*now = now.Add(2 * time.Minute)
+	limiter.Observe(http.Header{})
+	assert.Equal(t, 1.0, limiter.Rate())
+
+	limiter.Observe(http.Header{AppUsageHeader: {`{"call_count":5}`}})
+	limiter.Throttled()
+	assert.Equal(t, 2.5, limiter.Rate())
+}
+
+func TestRateLimiterThrottledOnlyLowersRate(t *testing.T) {
+	limiter, now := newFakeClockLimiter(10, 1)
+
+	// Usage of 90% or more already cut the rate below what a throttle would.
+	limiter.Observe(http.Header{AppUsageHeader: {`{"call_count":95}`}})
+	limiter.Throttled()
+	assert.Equal(t, 1.0, limiter.Rate())
+
+	// Without newer usage headers the rate recovers step by step.
+	*now = now.Add(rateRecoveryInterval)
+	limiter.reserve()
+	assert.Equal(t, 2.0, limiter.Rate())
+	limiter.Throttled()
+	assert.Equal(t, 2.0, limiter.Rate())
+	*now = now.Add(3 * rateRecoveryInterval)
+	limiter.reserve()
+	assert.Equal(t, 10.0, limiter.Rate())
+
+	limiter.Throttled()
+	assert.Equal(t, 2.5, limiter.Rate())
+	*now = now.Add(2 * rateRecoveryInterval)
+	limiter.reserve()
+	assert.Equal(t, 10.0, limiter.Rate())
+}
+
+func TestRateLimiterWaitHonoursContext(t *testing.T) {
+	limiter := NewRateLimiter(0.001, 1)
+	require.NoError(t, limiter.Wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, limiter.Wait(ctx), context.DeadlineExceeded)
+}
+
+func TestRateLimiterForPage(t *testing.T) {
+	appConfig := &config.AppConfig{PageID: "rate-test", SendRateLimit: 5, SendRateBurst: 1}
+	assert.Same(t, rateLimiterFor(appConfig), rateLimiterFor(appConfig))
+	assert.Equal(t, 5.0, rateLimiterFor(appConfig).Rate())
+
+	appConfig = &config.AppConfig{PageID: "rate-test-override", SendRateLimit: 5, PageSendRateLimits: map[string]float64{"rate-test-override": 2}}
+	assert.Equal(t, 2.0, rateLimiterFor(appConfig).Rate())
+
+	appConfig.PageSendRateLimits["rate-test-override"] = 0
+	assert.Nil(t, rateLimiterFor(appConfig))
+}
+
+func TestSendSlowsDownOnHighUsage(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+	appConfig := newTestConfig(server)
+	appConfig.PageID = "usage-test"
+	appConfig.SendRateLimit = 100
+	appConfig.SendRateBurst = 10
+
+	server.SetHeader(AppUsageHeader, `{"call_count":95,"total_time":10,"total_cputime":10}`)
+	require.NoError(t, SendMessage("44444444", "hello", appConfig))
+	assert.Equal(t, 10.0, rateLimiterFor(appConfig).Rate())
+
+	server.Reset()
+	server.FailNext(1, http.StatusBadRequest, fakegraph.CodeRateLimit, 0)
+	appConfig.SendMaxAttempts = 2
+	require.NoError(t, SendMessage("44444444", "hello", appConfig))
+	// The throttle must not raise the rate lowered for the high usage.
+	assert.Equal(t, 10.0, rateLimiterFor(appConfig).Rate())
+}
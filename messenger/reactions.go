@@ -0,0 +1,93 @@
+package messenger
+
+import "sync"
+
+// maxReactionObjects bounds how many posts and comments reactions are
+// remembered for; the ones first reacted to longest ago are dropped first.
+const maxReactionObjects = 10000
+
+// ReactionTally counts the reactions on the page's posts and comments. It
+// remembers each user's current reaction, so changing a reaction moves the
+// count rather than adding to it.
+type ReactionTally struct {
+	mu      sync.Mutex
+	objects map[string]*reactionObject
+	order   []string
+}
+
+// reactionObject holds the reaction counts of a post or comment and the
+// reaction of each user behind them.
+type reactionObject struct {
+	counts map[string]int
+	users  map[string]string
+}
+
+func NewReactionTally() *ReactionTally {
+	return &ReactionTally{objects: make(map[string]*reactionObject)}
+}
+
+// Set records reaction as userID's reaction to objectID.
+func (t *ReactionTally) Set(objectID string, userID string, reaction string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	object, ok := t.objects[objectID]
+	if !ok {
+		object = &reactionObject{counts: make(map[string]int), users: make(map[string]string)}
+		t.objects[objectID] = object
+		t.order = append(t.order, objectID)
+		if len(t.order) > maxReactionObjects {
+			delete(t.objects, t.order[0])
+			t.order = t.order[1:]
+		}
+	}
+	object.remove(userID)
+	object.counts[reaction]++
+	object.users[userID] = reaction
+}
+
+// Remove forgets userID's reaction to objectID.
+func (t *ReactionTally) Remove(objectID string, userID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if object, ok := t.objects[objectID]; ok {
+		object.remove(userID)
+	}
+}
+
+func (o *reactionObject) remove(userID string) {
+	previous, ok := o.users[userID]
+	if !ok {
+		return
+	}
+	delete(o.users, userID)
+	if o.counts[previous]--; o.counts[previous] <= 0 {
+		delete(o.counts, previous)
+	}
+}
+
+// Object returns the reaction counts of a post or comment.
+func (t *ReactionTally) Object(objectID string) map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := make(map[string]int)
+	if object, ok := t.objects[objectID]; ok {
+		for reaction, count := range object.counts {
+			counts[reaction] = count
+		}
+	}
+	return counts
+}
+
+// Totals returns the reaction counts across the remembered posts and
+// comments.
+func (t *ReactionTally) Totals() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	totals := make(map[string]int)
+	for _, object := range t.objects {
+		for reaction, count := range object.counts {
+			totals[reaction] += count
+		}
+	}
+	return totals
+}
@@ -1,93 +1,407 @@
 package messenger
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/qew21/fb-messenger/analysis"
 	"github.com/qew21/fb-messenger/assistant"
 	"github.com/qew21/fb-messenger/config"
+	"github.com/qew21/fb-messenger/webhook"
+	"github.com/rs/zerolog/log"
 )
 
-func getMessageText(messageMap map[string]interface{}) string {
-	messagePart := messageMap["message"].(map[string]interface{})
-	return messagePart["text"].(string)
+func getMessageText(event webhook.MessagingEvent) (string, error) {
+	if event.Message == nil {
+		return "", fmt.Errorf("messaging event from %s has no message", event.Sender.ID)
+	}
+	if event.Message.Text == "" {
+		return "", fmt.Errorf("message %s from %s has no text", event.Message.Mid, event.Sender.ID)
+	}
+	return event.Message.Text, nil
 }
 
-func getMessageSender(messageMap map[string]interface{}) string {
-	messagePart := messageMap["sender"].(map[string]interface{})
-	return messagePart["id"].(string)
+func getMessageSender(event webhook.MessagingEvent) string {
+	return event.Sender.ID
 }
 
-func analyzeSentimentBasedOnFieldType(field string, predictUrl string, value map[string]interface{}) (string, string, error) {
-	var sentiment string
-	var senderID string
+// analyzeSentimentBasedOnFieldType returns the sentiment of a change and the
+// comment to answer, which is empty when the change cannot be replied to.
+func analyzeSentimentBasedOnFieldType(change webhook.Change, analyzer analysis.Analyzer) (analysis.PredictionResult, string, error) {
+	var result analysis.PredictionResult
+	var commentID string
 
-	switch field {
-	case "feed":
-		senderID = value["post_id"].(string)
-		sentiment, err := analysis.Sentiment(predictUrl, value["message"].(string))
+	switch change.Field {
+	case webhook.FieldFeed:
+		value, err := change.Feed()
+		if err != nil {
+			return result, "", err
+		}
+		commentID = value.CommentID
+		result, err = analyzer.Analyze(context.Background(), value.Message)
 		if err != nil {
-			return sentiment, senderID, fmt.Errorf("failed to analyze feed sentiment: %w", err)
+			return result, commentID, fmt.Errorf("failed to analyze feed sentiment: %w", err)
 		}
-	case "ratings":
-		senderID = value["comment_id"].(string)
-		recommendationType := value["recommendation_type"].(string)
-		if recommendationType == "POSITIVE" {
-			sentiment = "positive"
+		result = analysis.Tag(result, value.Message)
+	case webhook.FieldRatings:
+		value, err := change.Ratings()
+		if err != nil {
+			return result, "", err
+		}
+		commentID = value.CommentID
+		// The reviewer chose the recommendation, so there is no doubt.
+		result.Probability = 1
+		if value.RecommendationType == "POSITIVE" {
+			result.Prediction = analysis.Positive
 		} else {
-			sentiment = "negative"
+			result.Prediction = analysis.Negative
 		}
+		result = analysis.Tag(result, value.ReviewText)
 	default:
-		return "", "", nil
-	}
-
-	return sentiment, senderID, nil
-}
-
-func ProcessMessage(update map[string]interface{}, appConfig *config.AppConfig, testMode bool) error {
-	switch update["object"].(string) {
-	case "page":
-		pageEntries := update["entry"].([]interface{})
-
-		for _, entry := range pageEntries {
-			entryMap := entry.(map[string]interface{})
-			changesOrMessaging, ok := entryMap["changes"]
-
-			if ok {
-				changes := changesOrMessaging.([]interface{})
-				for _, change := range changes {
-					changeMap := change.(map[string]interface{})
-					field := changeMap["field"].(string)
-					value := changeMap["value"].(map[string]interface{})
-
-					sentiment, senderID, err := analyzeSentimentBasedOnFieldType(field, appConfig.PredictUrl, value)
-					if err != nil {
-						return fmt.Errorf("failed to analyze %s sentiment: %w from %s", sentiment, err, senderID)
-					}
-					if !testMode {
-						if sentiment == "positive" {
-							SendMessage(senderID, "We're so glad to hear that! Could you share more about what you enjoyed?", appConfig)
-						} else if sentiment == "negative" {
-							SendMessage(senderID, "We're sorry to hear that. Could you share more about what went wrong?", appConfig)
-						}
-					}
-				}
-			} else if messaging, ok := entryMap["messaging"]; ok {
-				messages := messaging.([]interface{})
-				messageMap := messages[0].(map[string]interface{})
-				textValue := getMessageText(messageMap)
-				senderID := getMessageSender(messageMap)
-				if !testMode {
-					reply, _ := assistant.QianWen(senderID, textValue, appConfig.QianwenKey)
-					if reply != "" {
-						SendMessage(senderID, reply, appConfig)
-					}
-				}
+		return result, "", nil
+	}
+
+	return result, commentID, nil
+}
+
+// Processor handles the events received for the configured page.
+type Processor struct {
+	appConfig *config.AppConfig
+	testMode  bool
+	Status    *StatusTracker
+	Router    *Router
+	// DeadLetters, when set, keeps replies that could not be delivered.
+	DeadLetters DeadLetterStore
+	Sentiments  *SentimentStore
+	Reactions   *ReactionTally
+	Analyzer    analysis.Analyzer
+
+	feedHandlers map[feedRoute]FeedHandler
+
+	assistant func(userID string, message string, key string) (string, error)
+
+	pendingMu sync.Mutex
+	pending   map[string]pendingReply
+	now       func() time.Time
+}
+
+// pendingReplyTTL matches the 24 hour messaging window: after it the rest of
+// a reply could not be sent anyway.
+const pendingReplyTTL = 24 * time.Hour
+
+// pendingReply holds the parts of a reply kept for the Continue quick reply.
+type pendingReply struct {
+	parts   []string
+	expires time.Time
+}
+
+// NewProcessor returns a processor for appConfig. It fails when an attachment
+// policy is unknown or the configured sentiment analyzer cannot be built,
+// rather than behaving differently than configured.
+func NewProcessor(appConfig *config.AppConfig, testMode bool) (*Processor, error) {
+	if err := checkAttachmentPolicies(appConfig.AttachmentPolicy); err != nil {
+		return nil, err
+	}
+
+	p := &Processor{
+		appConfig: appConfig,
+		testMode:  testMode,
+		Status:    NewStatusTracker(),
+		Router:    NewRouter(),
+		assistant: assistant.QianWen,
+		pending:   make(map[string]pendingReply),
+		now:       time.Now,
+
+		Sentiments:   NewSentimentStore(),
+		Reactions:    NewReactionTally(),
+		feedHandlers: make(map[feedRoute]FeedHandler),
+	}
+	p.Router.Handle(ContinuePayload, p.handleContinue)
+	p.registerFeedHandlers()
+
+	analyzer, err := analysis.FromConfig(appConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sentiment analyzer: %w", err)
+	}
+	p.Analyzer = analyzer
+	return p, nil
+}
+
+// ProcessMessage handles every event of an update synchronously.
+func (p *Processor) ProcessMessage(update *webhook.Update) error {
+	if update.Object != webhook.ObjectPage {
+		return fmt.Errorf("unknown object type in payload: %q", update.Object)
+	}
+
+	events := Events(update)
+	var errs BatchError
+	for i, event := range events {
+		if err := p.ProcessEvent(event); err != nil {
+			errs = append(errs, &EventError{Index: i, Event: event, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// PrefetchSentiments scores the feed texts of an update in one batch, so
+// the events that follow are answered from the sentiment cache. Without a
+// cache the texts would only be scored twice. When the batch fails or ctx
+// expires, each event is scored on its own as without prefetching.
+func (p *Processor) PrefetchSentiments(ctx context.Context, events []Event) {
+	cache, ok := p.Analyzer.(*analysis.CachedAnalyzer)
+	if !ok {
+		return
+	}
+	var texts []string
+	for _, event := range events {
+		if event.Change != nil && event.Change.Field == webhook.FieldFeed && event.Text() != "" {
+			texts = append(texts, event.Text())
+		}
+	}
+	if len(texts) < 2 {
+		return
+	}
+	if _, err := cache.AnalyzeBatch(ctx, texts); err != nil {
+		log.Warn().Err(err).Int("texts", len(texts)).Msg("Failed to prefetch sentiments")
+	}
+}
+
+func (p *Processor) ProcessEvent(event Event) error {
+	if err := event.Validate(); err != nil {
+		return err
+	}
+
+	switch {
+	case event.Change != nil:
+		return p.processChange(*event.Change, event.Language)
+	case event.Messaging != nil:
+		return p.processMessaging(*event.Messaging)
+	}
+
+	return nil
+}
+
+func (p *Processor) processChange(change webhook.Change, language string) error {
+	if change.Field == webhook.FieldFeed {
+		return p.processFeed(change, language)
+	}
+
+	result, commentID, err := p.analyzeChange(change)
+	if err != nil {
+		return fmt.Errorf("failed to analyze %s sentiment: %w from %s", change.Field, err, commentID)
+	}
+	return p.replyBySentiment(commentID, result, language)
+}
+
+// analyzeChange scores a change. Predictions less likely than
+// SENTIMENT_THRESHOLD are treated as neutral so they are not answered.
+func (p *Processor) analyzeChange(change webhook.Change) (analysis.PredictionResult, string, error) {
+	result, commentID, err := analyzeSentimentBasedOnFieldType(change, p.Analyzer)
+	if err != nil {
+		return result, commentID, err
+	}
+
+	confident := result.Confident(p.appConfig.SentimentThreshold)
+	if confident.Prediction != result.Prediction {
+		log.Debug().Str("prediction", result.Prediction).Float64("probability", result.Probability).Str("comment_id", commentID).Msg("Treating uncertain sentiment as neutral")
+	}
+	return confident, commentID, nil
+}
+
+// replyBySentiment answers positive and negative comments in the language
+// they were written in, addressing what the comment is mostly about.
+func (p *Processor) replyBySentiment(commentID string, result analysis.PredictionResult, language string) error {
+	if commentID == "" {
+		return nil
+	}
+	if language == "" {
+		language = result.Language
+	}
+	text := aspectReply(language, result.Prediction, result.Aspect())
+	if text == "" {
+		return nil
+	}
+	return p.replyToComment(commentID, result.Prediction, text)
+}
+
+// processMessaging replies to user messages. Echoes of the page's own
+// messages, deliveries and reads only update the status tracker; replying to
+// them would loop.
+func (p *Processor) processMessaging(event webhook.MessagingEvent) error {
+	switch {
+	case event.Message != nil && event.Message.IsEcho:
+		p.Status.Sent(event.Message.Mid, event.Recipient.ID, event.Timestamp)
+		return nil
+	case event.Delivery != nil:
+		p.Status.Delivered(event.Sender.ID, event.Delivery.Mids, event.Delivery.Watermark)
+		return nil
+	case event.Read != nil:
+		p.Status.Read(event.Sender.ID, event.Read.Watermark)
+		return nil
+	}
+
+	if p.appConfig.PageID != "" && event.Sender.ID == p.appConfig.PageID {
+		return nil
+	}
+
+	// Registered payload handlers take precedence over the assistant.
+	payload, ok := payloadOf(event)
+	if ok {
+		if handler, found := p.Router.Match(payload.Payload); found {
+			return handler(payload)
+		}
+	}
+
+	var textValue string
+	if payload.Postback {
+		textValue = payload.Title
+	} else if event.Message != nil {
+		if len(event.Message.Attachments) > 0 {
+			if err := p.processAttachments(event); err != nil {
+				return err
+			}
+			if event.Message.Text == "" {
+				return nil
 			}
 		}
-	default:
-		return fmt.Errorf("unknown object type in payload")
+		var err error
+		textValue, err = getMessageText(event)
+		if err != nil {
+			return err
+		}
+	} else {
+		return nil
+	}
+
+	return p.replyWithAssistant(getMessageSender(event), textValue)
+}
+
+func (p *Processor) replyWithAssistant(senderID string, text string) error {
+	if p.testMode || text == "" {
+		return nil
+	}
+
+	// Assistant calls take seconds; show the user that the bot is working.
+	p.sendAction(senderID, SenderActionMarkSeen)
+	p.sendAction(senderID, SenderActionTypingOn)
+	defer p.sendAction(senderID, SenderActionTypingOff)
+
+	reply, err := p.assistant(senderID, text, p.appConfig.QianwenKey)
+	if err != nil {
+		return fmt.Errorf("assistant failed to reply to %s: %w", senderID, err)
+	}
+	if reply == "" {
+		return nil
+	}
+
+	return p.sendFormatted(senderID, reply)
+}
+
+// sendReply sends text in as many messages as the Messenger text limit
+// requires. Beyond REPLY_MAX_PARTS the rest is kept until the user taps the
+// Continue quick reply.
+func (p *Processor) sendReply(psid string, text string) error {
+	return p.sendParts(psid, SplitText(text, MaxTextLength))
+}
+
+func (p *Processor) sendParts(psid string, parts []string) error {
+	var rest []string
+	if maxParts := p.appConfig.ReplyMaxParts; maxParts > 0 && len(parts) > maxParts {
+		rest = parts[maxParts:]
+		parts = parts[:maxParts]
 	}
+	p.setPending(psid, rest)
 
+	for i, part := range parts {
+		message := NewTextMessage(part)
+		if i == len(parts)-1 && len(rest) > 0 {
+			message.WithQuickReplies(TextQuickReply(ContinueTitle, ContinuePayload))
+		}
+		if err := p.sendMessage(psid, message); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+func (p *Processor) handleContinue(payload PayloadEvent) error {
+	parts := p.takePending(payload.SenderID)
+	if len(parts) == 0 {
+		return nil
+	}
+	return p.sendParts(payload.SenderID, parts)
+}
+
+// setPending keeps parts for psid until they are taken or expire. Expired
+// replies, left by users who never tapped Continue, are dropped on every call.
+func (p *Processor) setPending(psid string, parts []string) {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+
+	now := p.now()
+	for id, reply := range p.pending {
+		if !now.Before(reply.expires) {
+			delete(p.pending, id)
+		}
+	}
+	if len(parts) == 0 {
+		delete(p.pending, psid)
+		return
+	}
+	p.pending[psid] = pendingReply{parts: parts, expires: now.Add(pendingReplyTTL)}
+}
+
+func (p *Processor) takePending(psid string) []string {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	reply, ok := p.pending[psid]
+	delete(p.pending, psid)
+	if !ok || !p.now().Before(reply.expires) {
+		return nil
+	}
+	return reply.parts
+}
+
+func (p *Processor) sendAction(psid string, action string) {
+	if p.testMode || !p.appConfig.SenderActions {
+		return
+	}
+	if err := SendAction(psid, action, p.appConfig); err != nil {
+		log.Warn().Err(err).Str("recipient", psid).Str("action", action).Msg("Failed to send sender action")
+	}
+}
+
+func (p *Processor) send(psid string, text string) error {
+	return p.sendMessage(psid, NewTextMessage(text))
+}
+
+func (p *Processor) sendMessage(psid string, message *Message) error {
+	if p.testMode {
+		return nil
+	}
+
+	err := Send(psid, message, p.appConfig)
+	p.keepDeadLetter(err)
+	return err
+}
+
+// keepDeadLetter stores a failed send so it can be replayed later.
+func (p *Processor) keepDeadLetter(err error) {
+	var sendErr *SendError
+	if p.DeadLetters == nil || !errors.As(err, &sendErr) {
+		return
+	}
+	letter, storeErr := p.DeadLetters.Add(NewDeadLetter(sendErr))
+	if storeErr != nil {
+		log.Error().Err(storeErr).Str("recipient", sendErr.Recipient).Msg("Failed to store dead letter")
+	} else {
+		log.Info().Str("id", letter.ID).Str("recipient", sendErr.Recipient).Msg("Stored dead letter")
+	}
+}
@@ -0,0 +1,141 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/qew21/fb-messenger/analysis"
+	"github.com/qew21/fb-messenger/config"
+	"github.com/qew21/fb-messenger/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadMock(t *testing.T, filename string) *webhook.Update {
+	data, err := ioutil.ReadFile(filepath.Join("..", "mock", filename))
+	require.NoError(t, err)
+	update, err := webhook.Decode(data)
+	require.NoError(t, err)
+	return update
+}
+
+func newTestProcessor(t *testing.T, appConfig *config.AppConfig, testMode bool) *Processor {
+	processor, err := NewProcessor(appConfig, testMode)
+	require.NoError(t, err)
+	return processor
+}
+
+func TestNewProcessorRejectsUnknownAnalyzer(t *testing.T) {
+	_, err := NewProcessor(&config.AppConfig{SentimentAnalyzer: "magic"}, true)
+	assert.ErrorContains(t, err, `unknown sentiment analyzer "magic"`)
+
+	_, err = NewProcessor(&config.AppConfig{SentimentAnalyzer: analysis.AnalyzerBayes, SentimentModel: filepath.Join(t.TempDir(), "missing.json")}, true)
+	assert.Error(t, err)
+}
+
+func TestEventsKeepsDeliveryOrder(t *testing.T) {
+	events := Events(loadMock(t, "batch.json"))
+	require.Len(t, events, 4)
+
+	var mids []string
+	for _, event := range events {
+		require.NotNil(t, event.Messaging)
+		mids = append(mids, event.Messaging.Message.Mid)
+	}
+	assert.Equal(t, []string{"mid.1", "mid.2", "mid.3", "mid.4"}, mids)
+}
+
+func TestProcessMessageCollectsEventErrors(t *testing.T) {
+	processor := newTestProcessor(t, &config.AppConfig{}, true)
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "batch.json")))
+
+	// A message with neither text nor attachments cannot be answered.
+	update := loadMock(t, "batch.json")
+	update.Entry[0].Messaging[1].Message = &webhook.Message{Mid: "mid.2"}
+	err := processor.ProcessMessage(update)
+
+	var batchErr BatchError
+	require.True(t, errors.As(err, &batchErr))
+	require.Len(t, batchErr, 1)
+	assert.Equal(t, 1, batchErr[0].Index)
+	assert.Equal(t, "mid.2", batchErr[0].Event.Messaging.Message.Mid)
+
+	// Malformed events fail on their own instead of rejecting the batch.
+	update, err = webhook.Decode([]byte(`{"object":"page","entry":[{"id":"0","messaging":[{"message":{"mid":"mid.0","text":"hi"}}],"changes":[{"value":{}}]}]}`))
+	require.NoError(t, err)
+	update.Entry[0].Messaging = append(update.Entry[0].Messaging, loadMock(t, "batch.json").Entry[0].Messaging[0])
+	err = processor.ProcessMessage(update)
+	require.True(t, errors.As(err, &batchErr))
+	require.Len(t, batchErr, 2)
+	assert.Equal(t, 0, batchErr[0].Index)
+	assert.ErrorContains(t, batchErr[0], "no sender")
+	assert.Equal(t, 2, batchErr[1].Index)
+	assert.ErrorContains(t, batchErr[1], "no field")
+
+	err = processor.ProcessMessage(&webhook.Update{Object: "user"})
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &batchErr))
+}
+
+func TestProcessEventTracksStatusWithoutReplying(t *testing.T) {
+	processor := newTestProcessor(t, &config.AppConfig{PageID: "0"}, true)
+
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "echo.json")))
+	record, ok := processor.Status.Status("Ukz9Pxgdi")
+	require.True(t, ok)
+	assert.Equal(t, StatusSent, record.Status)
+	assert.Equal(t, "44444444", record.RecipientID)
+
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "delivery.json")))
+	record, _ = processor.Status.Status("Ukz9Pxgdi")
+	assert.Equal(t, StatusDelivered, record.Status)
+
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "read.json")))
+	record, _ = processor.Status.Status("Ukz9Pxgdi")
+	assert.Equal(t, StatusRead, record.Status)
+
+	// A late delivery must not move a read message backwards.
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "delivery.json")))
+	record, _ = processor.Status.Status("Ukz9Pxgdi")
+	assert.Equal(t, StatusRead, record.Status)
+}
+
+func TestStatusTrackerWatermark(t *testing.T) {
+	tracker := NewStatusTracker()
+	tracker.Sent("mid.1", "psid", 100)
+	tracker.Sent("mid.2", "psid", 200)
+	tracker.Sent("mid.3", "other", 100)
+
+	tracker.Read("psid", 150)
+
+	record, _ := tracker.Status("mid.1")
+	assert.Equal(t, StatusRead, record.Status)
+	record, _ = tracker.Status("mid.2")
+	assert.Equal(t, StatusSent, record.Status)
+	record, _ = tracker.Status("mid.3")
+	assert.Equal(t, StatusSent, record.Status)
+}
+
+func TestStatusTrackerForgetsLeastRecentRecipients(t *testing.T) {
+	tracker := NewStatusTracker()
+	tracker.Sent("mid.first", "first", 100)
+	for i := 0; i < maxTrackedRecipients-1; i++ {
+		tracker.Sent(fmt.Sprintf("mid.%d", i), fmt.Sprintf("psid.%d", i), 100)
+	}
+	// Messaging "first" again keeps it over the next least recent recipient.
+	tracker.Sent("mid.again", "first", 200)
+	tracker.Sent("mid.new", "new", 100)
+
+	_, ok := tracker.Status("mid.first")
+	assert.True(t, ok)
+	_, ok = tracker.Status("mid.0")
+	assert.False(t, ok)
+
+	tracker.Read("psid.0", 100)
+	tracker.Read("first", 200)
+	record, _ := tracker.Status("mid.first")
+	assert.Equal(t, StatusRead, record.Status)
+}
@@ -0,0 +1,57 @@
+package messenger
+
+import "github.com/qew21/fb-messenger/analysis"
+
+// sentimentReplies answer comments and reviews by language and sentiment.
+var sentimentReplies = map[string]map[string]string{
+	analysis.LanguageEnglish: {
+		analysis.Positive: "We're so glad to hear that! Could you share more about what you enjoyed?",
+		analysis.Negative: "We're sorry to hear that. Could you share more about what went wrong?",
+	},
+	analysis.LanguageChinese: {
+		analysis.Positive: "很高兴听到您这么说！能和我们多分享一些您喜欢的地方吗？",
+		analysis.Negative: "很抱歉给您带来不好的体验。能告诉我们具体哪里出了问题吗？",
+	},
+}
+
+// sentimentReply returns the reply for sentiment in language, in English
+// when there is no reply in that language, or "" for sentiments that are not
+// answered.
+func sentimentReply(language string, sentiment string) string {
+	if replies, ok := sentimentReplies[language]; ok {
+		return replies[sentiment]
+	}
+	return sentimentReplies[analysis.LanguageEnglish][sentiment]
+}
+
+// aspectReplies answer negative comments about a specific aspect, so the
+// reply asks for what support needs to follow up.
+var aspectReplies = map[string]map[string]string{
+	analysis.LanguageEnglish: {
+		analysis.AspectShipping: "We're sorry about the trouble with your delivery. Could you send us your order number so we can check where it is?",
+		analysis.AspectPrice:    "We're sorry the price didn't feel right. Could you message us your order number so we can look into it?",
+		analysis.AspectQuality:  "We're sorry the product didn't hold up. Could you send us a photo and your order number so we can make it right?",
+		analysis.AspectService:  "We're sorry about your experience with our team. Could you tell us what happened so we can follow up?",
+	},
+	analysis.LanguageChinese: {
+		analysis.AspectShipping: "很抱歉配送给您带来了麻烦。能把订单号发给我们吗？我们马上帮您查询物流。",
+		analysis.AspectPrice:    "很抱歉价格让您不满意。能把订单号发给我们吗？我们会帮您核实。",
+		analysis.AspectQuality:  "很抱歉产品出现了质量问题。能发给我们照片和订单号吗？我们会尽快为您处理。",
+		analysis.AspectService:  "很抱歉我们的服务让您失望了。能告诉我们具体发生了什么吗？我们会跟进处理。",
+	},
+}
+
+// aspectReply returns the reply for a negative comment about aspect, or the
+// general reply for the sentiment.
+func aspectReply(language string, sentiment string, aspect string) string {
+	if sentiment == analysis.Negative && aspect != "" {
+		replies, ok := aspectReplies[language]
+		if !ok {
+			replies = aspectReplies[analysis.LanguageEnglish]
+		}
+		if text, ok := replies[aspect]; ok {
+			return text
+		}
+	}
+	return sentimentReply(language, sentiment)
+}
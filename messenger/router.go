@@ -0,0 +1,99 @@
+package messenger
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/qew21/fb-messenger/webhook"
+)
+
+// PayloadEvent is a postback or quick reply delivered to a PayloadHandler.
+type PayloadEvent struct {
+	SenderID string
+	Payload  string
+	// Title is the button title for postbacks and the message text for
+	// quick replies.
+	Title    string
+	Postback bool
+	Event    webhook.MessagingEvent
+}
+
+type PayloadHandler func(payload PayloadEvent) error
+
+type prefixRoute struct {
+	prefix  string
+	handler PayloadHandler
+}
+
+// Router dispatches postback and quick reply payloads to handlers registered
+// by exact payload or by prefix. Exact matches win over prefixes, and longer
+// prefixes win over shorter ones.
+type Router struct {
+	mu       sync.RWMutex
+	exact    map[string]PayloadHandler
+	prefixes []prefixRoute
+}
+
+func NewRouter() *Router {
+	return &Router{exact: make(map[string]PayloadHandler)}
+}
+
+func (r *Router) Handle(payload string, handler PayloadHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.exact[payload] = handler
+}
+
+func (r *Router) HandlePrefix(prefix string, handler PayloadHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, route := range r.prefixes {
+		if route.prefix == prefix {
+			r.prefixes[i].handler = handler
+			return
+		}
+	}
+	r.prefixes = append(r.prefixes, prefixRoute{prefix: prefix, handler: handler})
+	sort.SliceStable(r.prefixes, func(i, j int) bool {
+		return len(r.prefixes[i].prefix) > len(r.prefixes[j].prefix)
+	})
+}
+
+func (r *Router) Match(payload string) (PayloadHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if handler, ok := r.exact[payload]; ok {
+		return handler, true
+	}
+	for _, route := range r.prefixes {
+		if strings.HasPrefix(payload, route.prefix) {
+			return route.handler, true
+		}
+	}
+	return nil, false
+}
+
+// payloadOf extracts the routable payload of a messaging event, if any.
+func payloadOf(event webhook.MessagingEvent) (PayloadEvent, bool) {
+	switch {
+	case event.Postback != nil:
+		return PayloadEvent{
+			SenderID: event.Sender.ID,
+			Payload:  event.Postback.Payload,
+			Title:    event.Postback.Title,
+			Postback: true,
+			Event:    event,
+		}, true
+	case event.Message != nil && event.Message.QuickReply != nil:
+		return PayloadEvent{
+			SenderID: event.Sender.ID,
+			Payload:  event.Message.QuickReply.Payload,
+			Title:    event.Message.Text,
+			Event:    event,
+		}, true
+	}
+	return PayloadEvent{}, false
+}
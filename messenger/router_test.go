@@ -0,0 +1,65 @@
+package messenger
+
+import (
+	"testing"
+
+	"github.com/qew21/fb-messenger/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterMatch(t *testing.T) {
+	var matched string
+	router := NewRouter()
+	router.Handle("TOPIC_SHIPPING", func(payload PayloadEvent) error { matched = "exact"; return nil })
+	router.HandlePrefix("TOPIC_", func(payload PayloadEvent) error { matched = "topic"; return nil })
+	router.HandlePrefix("TOPIC_ORDER_", func(payload PayloadEvent) error { matched = "order"; return nil })
+
+	testCases := []struct {
+		payload  string
+		expected string
+	}{
+		{"TOPIC_SHIPPING", "exact"},
+		{"TOPIC_PRICE", "topic"},
+		{"TOPIC_ORDER_123", "order"},
+	}
+	for _, tc := range testCases {
+		handler, ok := router.Match(tc.payload)
+		require.True(t, ok, tc.payload)
+		require.NoError(t, handler(PayloadEvent{Payload: tc.payload}))
+		assert.Equal(t, tc.expected, matched, tc.payload)
+	}
+
+	_, ok := router.Match("GET_STARTED")
+	assert.False(t, ok)
+}
+
+func TestProcessorRoutesPayloads(t *testing.T) {
+	processor := newTestProcessor(t, &config.AppConfig{}, true)
+
+	var received []PayloadEvent
+	record := func(payload PayloadEvent) error {
+		received = append(received, payload)
+		return nil
+	}
+	processor.Router.Handle("GET_STARTED", record)
+	processor.Router.HandlePrefix("TOPIC_", record)
+
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "postback.json")))
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "quick_reply.json")))
+
+	require.Len(t, received, 2)
+	assert.True(t, received[0].Postback)
+	assert.Equal(t, "GET_STARTED", received[0].Payload)
+	assert.Equal(t, "Get Started", received[0].Title)
+	assert.False(t, received[1].Postback)
+	assert.Equal(t, "TOPIC_SHIPPING", received[1].Payload)
+	assert.Equal(t, "Shipping", received[1].Title)
+	assert.Equal(t, "44444444", received[1].SenderID)
+}
+
+func TestProcessorFallsBackWithoutRoute(t *testing.T) {
+	processor := newTestProcessor(t, &config.AppConfig{}, true)
+	assert.NoError(t, processor.ProcessMessage(loadMock(t, "postback.json")))
+	assert.NoError(t, processor.ProcessMessage(loadMock(t, "quick_reply.json")))
+}
@@ -2,67 +2,178 @@ package messenger
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/qew21/fb-messenger/config"
+	"github.com/rs/zerolog/log"
 )
 
+var sendClient = &http.Client{Timeout: 30 * time.Second}
+
 type Payload struct {
 	Recipient     Recipient `json:"recipient"`
 	Message       Message   `json:"message"`
 	MessagingType string    `json:"messaging_type"`
 }
 
+// Recipient addresses a user by PSID, or, for private replies, by the
+// comment they left on the page.
 type Recipient struct {
-	ID string `json:"id"`
+	ID        string `json:"id,omitempty"`
+	CommentID string `json:"comment_id,omitempty"`
 }
 
 type Message struct {
-	Text string `json:"text"`
+	Text         string       `json:"text,omitempty"`
+	Attachment   *Attachment  `json:"attachment,omitempty"`
+	QuickReplies []QuickReply `json:"quick_replies,omitempty"`
 }
 
 func SendMessage(psid string, messageText string, appConfig *config.AppConfig) error {
-	url := fmt.Sprintf("https://graph.facebook.com/%s/%s/messages", appConfig.APIVersion, appConfig.PageID)
-	accessToken := appConfig.PageAccesToken
-	recipientData := Recipient{ID: psid}
-	messageData := Message{Text: messageText}
+	return Send(psid, NewTextMessage(messageText), appConfig)
+}
+
+const (
+	SenderActionMarkSeen  = "mark_seen"
+	SenderActionTypingOn  = "typing_on"
+	SenderActionTypingOff = "typing_off"
+)
+
+type ActionPayload struct {
+	Recipient    Recipient `json:"recipient"`
+	SenderAction string    `json:"sender_action"`
+}
+
+// Send validates message and posts it to psid through the Send API.
+func Send(psid string, message *Message, appConfig *config.AppConfig) error {
+	if err := message.Validate(); err != nil {
+		return err
+	}
+
 	payload := Payload{
-		Recipient:     recipientData,
-		Message:       messageData,
+		Recipient:     Recipient{ID: psid},
+		Message:       *message,
 		MessagingType: "RESPONSE",
 	}
+	return postMessages(payload, psid, appConfig)
+}
+
+// SendAction shows a sender action such as a typing indicator to psid. It is
+// best effort: a single attempt that does not wait for the rate limiter, so
+// indicators never delay or use up the budget of the replies they announce.
+func SendAction(psid string, action string, appConfig *config.AppConfig) error {
+	payload := ActionPayload{
+		Recipient:    Recipient{ID: psid},
+		SenderAction: action,
+	}
 	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal payload: %w", err)
+	}
+
+	header, err := postJSON(graphURL(messagesEndpoint(appConfig), appConfig), appConfig.PageAccesToken, jsonPayload)
+	if limiter := rateLimiterFor(appConfig); limiter != nil {
+		observe(limiter, header, err)
+	}
+	return err
+}
+
+func postMessages(payload interface{}, recipient string, appConfig *config.AppConfig) error {
+	return postGraph(messagesEndpoint(appConfig), payload, recipient, appConfig)
+}
 
+func messagesEndpoint(appConfig *config.AppConfig) string {
+	return appConfig.PageID + "/messages"
+}
+
+// postGraph posts payload to endpoint, a Graph API path below the version
+// such as "{page-id}/messages", retrying transient failures.
+func postGraph(endpoint string, payload interface{}, recipient string, appConfig *config.AppConfig) error {
+	url := graphURL(endpoint, appConfig)
+	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("Failed to marshal payload: %w", err)
 	}
 
+	limiter := rateLimiterFor(appConfig)
+	maxAttempts := appConfig.SendMaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	attempt := 1
+	for ; ; attempt++ {
+		if limiter != nil {
+			limiter.Wait(context.Background())
+		}
+		var header http.Header
+		header, err = postJSON(url, appConfig.PageAccesToken, jsonPayload)
+		if limiter != nil {
+			observe(limiter, header, err)
+		}
+		if err == nil {
+			return nil
+		}
+		if attempt >= maxAttempts || !isTransient(err) {
+			break
+		}
+		delay := backoff(appConfig.SendRetryBaseDelay, appConfig.SendRetryMaxDelay, attempt)
+		log.Debug().Err(err).Str("recipient", recipient).Int("attempt", attempt).Dur("delay", delay).Msg("Retrying send")
+		time.Sleep(delay)
+	}
+
+	sendErr := &SendError{Recipient: recipient, Endpoint: endpoint, Payload: jsonPayload, Attempts: attempt, Err: err}
+	event := log.Warn().Err(err).Str("recipient", recipient).Int("attempts", attempt)
+	var graphErr *GraphError
+	if errors.As(err, &graphErr) {
+		event = event.Int("code", graphErr.Code).Int("subcode", graphErr.Subcode).Str("fbtrace_id", graphErr.FBTraceID)
+	}
+	event.Msg("Send API request failed")
+	return sendErr
+}
+
+func graphURL(endpoint string, appConfig *config.AppConfig) string {
+	return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(appConfig.GraphAPIURL, "/"), appConfig.APIVersion, endpoint)
+}
+
+// observe lets limiter adapt to the usage reported by a response and to
+// rate limit errors.
+func observe(limiter *RateLimiter, header http.Header, err error) {
+	limiter.Observe(header)
+	var graphErr *GraphError
+	if errors.As(err, &graphErr) && graphErr.RateLimited() {
+		limiter.Throttled()
+	}
+}
+
+func postJSON(url string, accessToken string, jsonPayload []byte) (http.Header, error) {
 	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonPayload))
 	if err != nil {
-		return fmt.Errorf("Failed to create request: %w", err)
+		return nil, fmt.Errorf("Failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := sendClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("Failed to send request: %w", err)
+		return nil, fmt.Errorf("Failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		return nil
-	}
-
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("Failed to read response body: %w", err)
+		return resp.Header, fmt.Errorf("Failed to read response body: %w", err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp.Header, nil
 	}
 
-	return fmt.Errorf("Failed to send message: %s", body)
+	return resp.Header, parseGraphError(resp.StatusCode, body)
 }
@@ -0,0 +1,129 @@
+package messenger
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/qew21/fb-messenger/config"
+	"github.com/qew21/fb-messenger/fakegraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActionPayloadMarshal(t *testing.T) {
+	data, err := json.Marshal(ActionPayload{Recipient: Recipient{ID: "44444444"}, SenderAction: SenderActionTypingOn})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"recipient": {"id": "44444444"}, "sender_action": "typing_on"}`, string(data))
+}
+
+func newTestConfig(server *fakegraph.Server) *config.AppConfig {
+	return &config.AppConfig{
+		GraphAPIURL:    server.URL,
+		APIVersion:     "v19.0",
+		PageID:         "0",
+		PageAccesToken: "page_token",
+		SenderActions:  true,
+	}
+}
+
+func TestSendUsesGraphAPIURL(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+	appConfig := newTestConfig(server)
+
+	require.NoError(t, Send("44444444", NewButtonTemplate("Need help?", PostbackButton("Yes", "HELP")), appConfig))
+	require.NoError(t, SendAction("44444444", SenderActionMarkSeen, appConfig))
+
+	requests := server.Requests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "/v19.0/0/messages", requests[0].Path)
+	assert.Equal(t, "page_token", requests[0].AccessToken)
+	messages := server.Messages()
+	require.Len(t, messages, 1)
+	assert.JSONEq(t, `{"attachment":{"type":"template","payload":{"template_type":"button","text":"Need help?","buttons":[{"type":"postback","title":"Yes","payload":"HELP"}]}}}`, string(messages[0].Message))
+
+	server.FailNext(1, http.StatusBadRequest, fakegraph.CodeUserUnavailable, 0)
+	assert.Error(t, SendMessage("44444444", "hello", appConfig))
+
+	assert.ErrorIs(t, Send("44444444", &Message{}, appConfig), ErrInvalidMessage)
+	assert.Len(t, server.Requests(), 3)
+}
+
+func TestSendActionIsBestEffort(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+	appConfig := newTestConfig(server)
+	appConfig.PageID = "sender-actions"
+	appConfig.SendMaxAttempts = 4
+	appConfig.SendRateLimit = 1
+	appConfig.SendRateBurst = 1
+
+	// Actions neither wait for nor use up the one token in the bucket.
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, SendAction("44444444", SenderActionTypingOn, appConfig))
+	}
+	require.NoError(t, SendMessage("44444444", "hello", appConfig))
+	assert.Less(t, time.Since(started), 500*time.Millisecond)
+
+	// A failed action is not retried.
+	server.Reset()
+	server.FailNext(1, http.StatusInternalServerError, 2, 0)
+	assert.Error(t, SendAction("44444444", SenderActionTypingOn, appConfig))
+	assert.Len(t, server.Requests(), 1)
+}
+
+func TestAssistantReplyShowsTypingIndicator(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+
+	processor := newTestProcessor(t, newTestConfig(server), false)
+	processor.assistant = func(userID string, message string, key string) (string, error) {
+		return "Hi! How can I help you?", nil
+	}
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "message.json")))
+
+	var sequence []string
+	for _, message := range server.All() {
+		if message.SenderAction != "" {
+			sequence = append(sequence, message.SenderAction)
+		} else {
+			sequence = append(sequence, message.Text)
+		}
+	}
+	assert.Equal(t, []string{SenderActionMarkSeen, SenderActionTypingOn, "Hi! How can I help you?", SenderActionTypingOff}, sequence)
+
+	server.Reset()
+	processor.appConfig.SenderActions = false
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "message.json")))
+	assert.Empty(t, server.Actions())
+	assert.Len(t, server.Messages(), 1)
+}
+
+func TestAssistantReplyFormatsMarkdown(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+
+	processor := newTestProcessor(t, newTestConfig(server), false)
+	processor.appConfig.SenderActions = false
+	processor.assistant = func(userID string, message string, key string) (string, error) {
+		return "**Tracking:** see [your order](https://example.com/o/1).", nil
+	}
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "message.json")))
+
+	messages := server.Messages()
+	require.Len(t, messages, 1)
+	assert.JSONEq(t, `{"attachment":{"type":"template","payload":{"template_type":"button","text":"Tracking: see your order.","buttons":[{"type":"web_url","title":"your order","url":"https://example.com/o/1"}]}}}`, string(messages[0].Message))
+
+	server.Reset()
+	processor.assistant = func(userID string, message string, key string) (string, error) {
+		return "- one [a](https://a.io)\n- two [b](https://b.io)\n- three [c](https://c.io)\n- four [d](https://d.io)", nil
+	}
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "message.json")))
+
+	messages = server.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "• one a (https://a.io)\n• two b (https://b.io)\n• three c (https://c.io)\n• four d (https://d.io)", messages[0].Text)
+}
@@ -0,0 +1,109 @@
+package messenger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/qew21/fb-messenger/analysis"
+)
+
+// maxSentimentRecords bounds how many posts and comments are remembered; the
+// oldest records are dropped first.
+const maxSentimentRecords = 10000
+
+// SentimentRecord is the latest sentiment of a post or comment on the page.
+type SentimentRecord struct {
+	ID          string    `json:"id"`
+	PostID      string    `json:"post_id"`
+	Item        string    `json:"item"`
+	AuthorID    string    `json:"author_id,omitempty"`
+	Sentiment   string    `json:"sentiment"`
+	Probability float64   `json:"probability,omitempty"`
+	Language    string    `json:"language,omitempty"`
+	Emotions    []string  `json:"emotions,omitempty"`
+	Aspects     []string  `json:"aspects,omitempty"`
+	Edited      bool      `json:"edited,omitempty"`
+	Hidden      bool      `json:"hidden,omitempty"`
+	Removed     bool      `json:"removed,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SentimentStore keeps the sentiment of feed posts and comments so edits
+// and removals can update it instead of producing new replies.
+type SentimentStore struct {
+	mu      sync.Mutex
+	records map[string]*SentimentRecord
+	order   []string
+}
+
+func NewSentimentStore() *SentimentStore {
+	return &SentimentStore{records: make(map[string]*SentimentRecord)}
+}
+
+// Set stores record, replacing any earlier record with the same ID.
+func (s *SentimentStore) Set(record SentimentRecord) {
+	s.Update(record.ID, func(stored *SentimentRecord) {
+		*stored = record
+	})
+}
+
+// Update applies change to the record with id, creating it when unknown.
+func (s *SentimentStore) Update(id string, change func(record *SentimentRecord)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		record = &SentimentRecord{}
+		s.records[id] = record
+		s.order = append(s.order, id)
+		if len(s.order) > maxSentimentRecords {
+			delete(s.records, s.order[0])
+			s.order = s.order[1:]
+		}
+	}
+	change(record)
+	record.ID = id
+	record.UpdatedAt = time.Now()
+}
+
+func (s *SentimentStore) Get(id string) (SentimentRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[id]
+	if !ok {
+		return SentimentRecord{}, false
+	}
+	return *record, true
+}
+
+// AspectCounts returns how many visible negative posts and comments are
+// about each aspect, which is where support should look first.
+func (s *SentimentStore) AspectCounts() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int)
+	for _, record := range s.records {
+		if record.Hidden || record.Removed || record.Sentiment != analysis.Negative {
+			continue
+		}
+		for _, aspect := range record.Aspects {
+			counts[aspect]++
+		}
+	}
+	return counts
+}
+
+// Counts returns how many visible posts and comments have each sentiment.
+func (s *SentimentStore) Counts() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int)
+	for _, record := range s.records {
+		if record.Hidden || record.Removed || record.Sentiment == "" {
+			continue
+		}
+		counts[record.Sentiment]++
+	}
+	return counts
+}
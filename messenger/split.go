@@ -0,0 +1,78 @@
+package messenger
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	ContinuePayload = "CONTINUE_REPLY"
+	ContinueTitle   = "Continue"
+)
+
+// SplitText splits text into parts of at most max characters. It cuts at
+// the last paragraph break that fits, then line break, sentence end and word
+// break, and only cuts inside a word when nothing else is available. Lengths
+// are counted in characters so CJK text is never split inside a rune.
+func SplitText(text string, max int) []string {
+	runes := []rune(strings.TrimSpace(text))
+	if max < 1 {
+		max = MaxTextLength
+	}
+
+	var parts []string
+	for len(runes) > max {
+		cut := splitPoint(runes[:max+1])
+		part := strings.TrimSpace(string(runes[:cut]))
+		if part != "" {
+			parts = append(parts, part)
+		}
+		runes = []rune(strings.TrimLeftFunc(string(runes[cut:]), unicode.IsSpace))
+	}
+	if len(runes) > 0 {
+		parts = append(parts, string(runes))
+	}
+	return parts
+}
+
+// splitPoint returns where to cut window, whose last rune is the first one
+// that does not fit. Boundaries in the first third are ignored so that parts
+// do not become too short.
+func splitPoint(window []rune) int {
+	max := len(window) - 1
+	min := max / 3
+
+	boundaries := []func(i int) bool{
+		// Paragraph break.
+		func(i int) bool { return window[i] == '\n' && i > 0 && window[i-1] == '\n' },
+		// Line break.
+		func(i int) bool { return window[i] == '\n' },
+		// Sentence end: CJK punctuation needs no following space.
+		func(i int) bool {
+			switch window[i-1] {
+			case '。', '！', '？', '；':
+				return true
+			case '.', '!', '?':
+				return unicode.IsSpace(window[i])
+			}
+			return false
+		},
+		// Word or clause break.
+		func(i int) bool {
+			switch window[i-1] {
+			case '，', '、', '：':
+				return true
+			}
+			return unicode.IsSpace(window[i])
+		},
+	}
+
+	for _, isBoundary := range boundaries {
+		for i := max; i > min; i-- {
+			if isBoundary(i) {
+				return i
+			}
+		}
+	}
+	return max
+}
@@ -0,0 +1,108 @@
+package messenger
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/qew21/fb-messenger/config"
+	"github.com/qew21/fb-messenger/fakegraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitText(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		max      int
+		expected []string
+	}{
+		{"Short", "hello", 20, []string{"hello"}},
+		{"Paragraph", "First paragraph.\n\nSecond one here.", 30, []string{"First paragraph.", "Second one here."}},
+		{"Sentence", "One sentence here. Another sentence here.", 30, []string{"One sentence here.", "Another sentence here."}},
+		{"Words", "alpha beta gamma delta epsilon", 12, []string{"alpha beta", "gamma delta", "epsilon"}},
+		{"HardCut", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"CJKSentence", "你好，欢迎光临。请问有什么可以帮您？谢谢。", 10, []string{"你好，欢迎光临。", "请问有什么可以帮您？", "谢谢。"}},
+		{"Decimal", "Price is 3.50 dollars today", 15, []string{"Price is 3.50", "dollars today"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, SplitText(tc.text, tc.max))
+		})
+	}
+}
+
+func TestSplitTextLongReply(t *testing.T) {
+	paragraph := strings.Repeat("这是一个很长的句子。", 50) + "\n\n" + strings.Repeat("This is a sentence. ", 40)
+	text := strings.Repeat(paragraph+"\n\n", 3)
+
+	parts := SplitText(text, MaxTextLength)
+	require.Greater(t, len(parts), 1)
+	var joined []string
+	for _, part := range parts {
+		assert.True(t, utf8.ValidString(part))
+		assert.LessOrEqual(t, utf8.RuneCountInString(part), MaxTextLength)
+		joined = append(joined, part)
+	}
+	assert.Equal(t, strings.Join(strings.Fields(text), ""), strings.Join(strings.Fields(strings.Join(joined, " ")), ""))
+}
+
+func TestProcessorSplitsLongReplies(t *testing.T) {
+	server := fakegraph.NewServer()
+	defer server.Close()
+	appConfig := newTestConfig(server)
+	appConfig.SenderActions = false
+	appConfig.ReplyMaxParts = 2
+
+	processor := newTestProcessor(t, appConfig, false)
+	reply := strings.Repeat("a", 1500) + "\n\n" + strings.Repeat("b", 1500) + "\n\n" + strings.Repeat("c", 1500)
+	processor.assistant = func(userID string, message string, key string) (string, error) {
+		return reply, nil
+	}
+
+	require.NoError(t, processor.ProcessMessage(loadMock(t, "message.json")))
+	messages := server.Messages()
+	require.Len(t, messages, 2)
+	assert.Equal(t, strings.Repeat("a", 1500), messages[0].Text)
+	assert.Equal(t, strings.Repeat("b", 1500), messages[1].Text)
+	assert.NotContains(t, string(messages[0].Message), ContinuePayload)
+	assert.Contains(t, string(messages[1].Message), ContinuePayload)
+
+	update := loadMock(t, "quick_reply.json")
+	update.Entry[0].Messaging[0].Message.Text = ContinueTitle
+	update.Entry[0].Messaging[0].Message.QuickReply.Payload = ContinuePayload
+	require.NoError(t, processor.ProcessMessage(update))
+	messages = server.Messages()
+	require.Len(t, messages, 3)
+	assert.Equal(t, strings.Repeat("c", 1500), messages[2].Text)
+	assert.NotContains(t, string(messages[2].Message), ContinuePayload)
+
+	// Nothing is left to continue.
+	require.NoError(t, processor.ProcessMessage(update))
+	assert.Len(t, server.Messages(), 3)
+}
+
+func TestProcessorSendsAllPartsWithoutLimit(t *testing.T) {
+	processor := newTestProcessor(t, &config.AppConfig{}, true)
+	require.NoError(t, processor.sendParts("44444444", []string{"a", "b", "c", "d"}))
+	assert.Empty(t, processor.takePending("44444444"))
+}
+
+func TestPendingRepliesExpire(t *testing.T) {
+	processor := newTestProcessor(t, &config.AppConfig{}, true)
+	now := time.Unix(1710061072, 0)
+	processor.now = func() time.Time { return now }
+
+	processor.setPending("1", []string{"rest"})
+	now = now.Add(pendingReplyTTL)
+	assert.Empty(t, processor.takePending("1"))
+
+	processor.setPending("2", []string{"rest"})
+	now = now.Add(pendingReplyTTL)
+	// Adding a reply drops the ones that expired.
+	processor.setPending("3", []string{"rest"})
+	assert.Len(t, processor.pending, 1)
+	assert.Equal(t, []string{"rest"}, processor.takePending("3"))
+}
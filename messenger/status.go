@@ -0,0 +1,163 @@
+package messenger
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// maxTrackedPerRecipient bounds how many messages are remembered per user.
+	maxTrackedPerRecipient = 100
+	// maxTrackedRecipients bounds how many users messages are remembered for;
+	// the users least recently messaged are forgotten first.
+	maxTrackedRecipients = 10000
+)
+
+type MessageStatus int
+
+const (
+	StatusSent MessageStatus = iota + 1
+	StatusDelivered
+	StatusRead
+)
+
+func (s MessageStatus) String() string {
+	switch s {
+	case StatusSent:
+		return "sent"
+	case StatusDelivered:
+		return "delivered"
+	case StatusRead:
+		return "read"
+	default:
+		return "unknown"
+	}
+}
+
+func (s MessageStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type MessageRecord struct {
+	Mid         string        `json:"mid"`
+	RecipientID string        `json:"recipient_id"`
+	Status      MessageStatus `json:"status"`
+	Timestamp   int64         `json:"timestamp"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// StatusTracker follows the messages the page has sent through the echo,
+// delivery and read events Messenger posts back to the webhook.
+type StatusTracker struct {
+	mu          sync.Mutex
+	messages    map[string]*MessageRecord
+	recipients  *list.List
+	byRecipient map[string]*list.Element
+}
+
+// recipientMessages are the messages sent to one user, oldest first.
+type recipientMessages struct {
+	recipientID string
+	records     []*MessageRecord
+}
+
+func NewStatusTracker() *StatusTracker {
+	return &StatusTracker{
+		messages:    make(map[string]*MessageRecord),
+		recipients:  list.New(),
+		byRecipient: make(map[string]*list.Element),
+	}
+}
+
+// Sent records a message echoed back from the page.
+func (t *StatusTracker) Sent(mid string, recipientID string, timestamp int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.messages[mid]; ok {
+		return
+	}
+	record := &MessageRecord{Mid: mid, RecipientID: recipientID, Status: StatusSent, Timestamp: timestamp, UpdatedAt: time.Now()}
+	t.messages[mid] = record
+
+	element, ok := t.byRecipient[recipientID]
+	if ok {
+		t.recipients.MoveToFront(element)
+	} else {
+		element = t.recipients.PushFront(&recipientMessages{recipientID: recipientID})
+		t.byRecipient[recipientID] = element
+	}
+	recipient := element.Value.(*recipientMessages)
+	recipient.records = append(recipient.records, record)
+	if len(recipient.records) > maxTrackedPerRecipient {
+		t.forget(recipient.records[:len(recipient.records)-maxTrackedPerRecipient])
+		recipient.records = recipient.records[len(recipient.records)-maxTrackedPerRecipient:]
+	}
+
+	for t.recipients.Len() > maxTrackedRecipients {
+		oldest := t.recipients.Back()
+		t.recipients.Remove(oldest)
+		recipient := oldest.Value.(*recipientMessages)
+		delete(t.byRecipient, recipient.recipientID)
+		t.forget(recipient.records)
+	}
+}
+
+func (t *StatusTracker) forget(records []*MessageRecord) {
+	for _, record := range records {
+		delete(t.messages, record.Mid)
+	}
+}
+
+// Delivered marks mids, and every message sent to recipientID up to the
+// watermark, as delivered.
+func (t *StatusTracker) Delivered(recipientID string, mids []string, watermark int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, mid := range mids {
+		if record, ok := t.messages[mid]; ok {
+			t.advance(record, StatusDelivered)
+		}
+	}
+	t.advanceUntil(recipientID, watermark, StatusDelivered)
+}
+
+// Read marks every message sent to recipientID up to the watermark as read.
+func (t *StatusTracker) Read(recipientID string, watermark int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.advanceUntil(recipientID, watermark, StatusRead)
+}
+
+func (t *StatusTracker) Status(mid string) (MessageRecord, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	record, ok := t.messages[mid]
+	if !ok {
+		return MessageRecord{}, false
+	}
+	return *record, true
+}
+
+func (t *StatusTracker) advanceUntil(recipientID string, watermark int64, status MessageStatus) {
+	element, ok := t.byRecipient[recipientID]
+	if !ok {
+		return
+	}
+	for _, record := range element.Value.(*recipientMessages).records {
+		if record.Timestamp <= watermark {
+			t.advance(record, status)
+		}
+	}
+}
+
+func (t *StatusTracker) advance(record *MessageRecord, status MessageStatus) {
+	if record.Status < status {
+		record.Status = status
+		record.UpdatedAt = time.Now()
+	}
+}
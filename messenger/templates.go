@@ -0,0 +1,287 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Messenger Send API limits, checked by Message.Validate before sending.
+const (
+	MaxTextLength            = 2000
+	MaxQuickReplies          = 13
+	MaxQuickReplyTitleLength = 20
+	MaxPayloadLength         = 1000
+	MaxButtonTemplateText    = 640
+	MaxButtons               = 3
+	MaxButtonTitleLength     = 20
+	MaxGenericElements       = 10
+	MaxElementTitleLength    = 80
+	MaxElementSubtitleLength = 80
+)
+
+const (
+	AttachmentTypeImage    = "image"
+	AttachmentTypeAudio    = "audio"
+	AttachmentTypeVideo    = "video"
+	AttachmentTypeFile     = "file"
+	AttachmentTypeTemplate = "template"
+
+	TemplateButton  = "button"
+	TemplateGeneric = "generic"
+	TemplateMedia   = "media"
+
+	ButtonWebURL      = "web_url"
+	ButtonPostback    = "postback"
+	ButtonPhoneNumber = "phone_number"
+
+	QuickReplyText = "text"
+)
+
+var ErrInvalidMessage = errors.New("invalid message")
+
+type QuickReply struct {
+	ContentType string `json:"content_type"`
+	Title       string `json:"title,omitempty"`
+	Payload     string `json:"payload,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+}
+
+type Attachment struct {
+	Type    string            `json:"type"`
+	Payload AttachmentPayload `json:"payload"`
+}
+
+// AttachmentPayload is either a media reference (URL or AttachmentID) or a
+// template, depending on the attachment type.
+type AttachmentPayload struct {
+	URL          string    `json:"url,omitempty"`
+	AttachmentID string    `json:"attachment_id,omitempty"`
+	IsReusable   bool      `json:"is_reusable,omitempty"`
+	TemplateType string    `json:"template_type,omitempty"`
+	Text         string    `json:"text,omitempty"`
+	Buttons      []Button  `json:"buttons,omitempty"`
+	Elements     []Element `json:"elements,omitempty"`
+}
+
+type Button struct {
+	Type    string `json:"type"`
+	Title   string `json:"title"`
+	URL     string `json:"url,omitempty"`
+	Payload string `json:"payload,omitempty"`
+}
+
+type DefaultAction struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// Element is an item of a generic or media template. Generic elements use
+// Title, Subtitle and ImageURL; media elements use MediaType with either URL
+// or AttachmentID.
+type Element struct {
+	Title         string         `json:"title,omitempty"`
+	Subtitle      string         `json:"subtitle,omitempty"`
+	ImageURL      string         `json:"image_url,omitempty"`
+	DefaultAction *DefaultAction `json:"default_action,omitempty"`
+	MediaType     string         `json:"media_type,omitempty"`
+	URL           string         `json:"url,omitempty"`
+	AttachmentID  string         `json:"attachment_id,omitempty"`
+	Buttons       []Button       `json:"buttons,omitempty"`
+}
+
+func NewTextMessage(text string) *Message {
+	return &Message{Text: text}
+}
+
+// NewAttachmentMessage sends media hosted at url.
+func NewAttachmentMessage(attachmentType string, url string) *Message {
+	return &Message{Attachment: &Attachment{Type: attachmentType, Payload: AttachmentPayload{URL: url}}}
+}
+
+// NewAttachmentIDMessage sends media previously uploaded to the Attachment
+// Upload API.
+func NewAttachmentIDMessage(attachmentType string, attachmentID string) *Message {
+	return &Message{Attachment: &Attachment{Type: attachmentType, Payload: AttachmentPayload{AttachmentID: attachmentID}}}
+}
+
+func NewButtonTemplate(text string, buttons ...Button) *Message {
+	return newTemplate(AttachmentPayload{TemplateType: TemplateButton, Text: text, Buttons: buttons})
+}
+
+func NewGenericTemplate(elements ...Element) *Message {
+	return newTemplate(AttachmentPayload{TemplateType: TemplateGeneric, Elements: elements})
+}
+
+func NewMediaTemplate(element Element) *Message {
+	return newTemplate(AttachmentPayload{TemplateType: TemplateMedia, Elements: []Element{element}})
+}
+
+func newTemplate(payload AttachmentPayload) *Message {
+	return &Message{Attachment: &Attachment{Type: AttachmentTypeTemplate, Payload: payload}}
+}
+
+func URLButton(title string, url string) Button {
+	return Button{Type: ButtonWebURL, Title: title, URL: url}
+}
+
+func PostbackButton(title string, payload string) Button {
+	return Button{Type: ButtonPostback, Title: title, Payload: payload}
+}
+
+func CallButton(title string, phoneNumber string) Button {
+	return Button{Type: ButtonPhoneNumber, Title: title, Payload: phoneNumber}
+}
+
+func TextQuickReply(title string, payload string) QuickReply {
+	return QuickReply{ContentType: QuickReplyText, Title: title, Payload: payload}
+}
+
+func (m *Message) WithQuickReplies(replies ...QuickReply) *Message {
+	m.QuickReplies = append(m.QuickReplies, replies...)
+	return m
+}
+
+// Validate checks the message against the Send API limits so that an invalid
+// message fails locally instead of with a Graph API error.
+func (m *Message) Validate() error {
+	if (m.Text == "") == (m.Attachment == nil) {
+		return invalidMessage("message needs exactly one of text or attachment")
+	}
+	if err := checkLength("text", m.Text, MaxTextLength); err != nil {
+		return err
+	}
+
+	if len(m.QuickReplies) > MaxQuickReplies {
+		return invalidMessage("%d quick replies exceed the limit of %d", len(m.QuickReplies), MaxQuickReplies)
+	}
+	for i, reply := range m.QuickReplies {
+		if reply.ContentType == QuickReplyText {
+			if reply.Title == "" {
+				return invalidMessage("quick reply %d has no title", i)
+			}
+			if reply.Payload == "" {
+				return invalidMessage("quick reply %d has no payload", i)
+			}
+		}
+		if err := checkLength(fmt.Sprintf("quick reply %d title", i), reply.Title, MaxQuickReplyTitleLength); err != nil {
+			return err
+		}
+		if err := checkLength(fmt.Sprintf("quick reply %d payload", i), reply.Payload, MaxPayloadLength); err != nil {
+			return err
+		}
+	}
+
+	if m.Attachment != nil {
+		return m.Attachment.validate()
+	}
+	return nil
+}
+
+func (a *Attachment) validate() error {
+	payload := a.Payload
+	switch a.Type {
+	case AttachmentTypeImage, AttachmentTypeAudio, AttachmentTypeVideo, AttachmentTypeFile:
+		if (payload.URL == "") == (payload.AttachmentID == "") {
+			return invalidMessage("%s attachment needs exactly one of url or attachment_id", a.Type)
+		}
+		return nil
+	case AttachmentTypeTemplate:
+	default:
+		return invalidMessage("unknown attachment type %q", a.Type)
+	}
+
+	switch payload.TemplateType {
+	case TemplateButton:
+		if payload.Text == "" {
+			return invalidMessage("button template has no text")
+		}
+		if err := checkLength("button template text", payload.Text, MaxButtonTemplateText); err != nil {
+			return err
+		}
+		if len(payload.Buttons) == 0 {
+			return invalidMessage("button template has no buttons")
+		}
+		return validateButtons("button template", payload.Buttons)
+	case TemplateGeneric:
+		if len(payload.Elements) == 0 || len(payload.Elements) > MaxGenericElements {
+			return invalidMessage("generic template needs 1 to %d elements, got %d", MaxGenericElements, len(payload.Elements))
+		}
+		for i, element := range payload.Elements {
+			name := fmt.Sprintf("element %d", i)
+			if element.Title == "" {
+				return invalidMessage("%s has no title", name)
+			}
+			if err := checkLength(name+" title", element.Title, MaxElementTitleLength); err != nil {
+				return err
+			}
+			if err := checkLength(name+" subtitle", element.Subtitle, MaxElementSubtitleLength); err != nil {
+				return err
+			}
+			if element.DefaultAction != nil && element.DefaultAction.URL == "" {
+				return invalidMessage("%s default action has no url", name)
+			}
+			if err := validateButtons(name, element.Buttons); err != nil {
+				return err
+			}
+		}
+		return nil
+	case TemplateMedia:
+		if len(payload.Elements) != 1 {
+			return invalidMessage("media template needs exactly 1 element, got %d", len(payload.Elements))
+		}
+		element := payload.Elements[0]
+		if element.MediaType != AttachmentTypeImage && element.MediaType != AttachmentTypeVideo {
+			return invalidMessage("media template type must be image or video, got %q", element.MediaType)
+		}
+		if (element.URL == "") == (element.AttachmentID == "") {
+			return invalidMessage("media template needs exactly one of url or attachment_id")
+		}
+		return validateButtons("media template", element.Buttons)
+	default:
+		return invalidMessage("unknown template type %q", payload.TemplateType)
+	}
+}
+
+func validateButtons(name string, buttons []Button) error {
+	if len(buttons) > MaxButtons {
+		return invalidMessage("%s has %d buttons, the limit is %d", name, len(buttons), MaxButtons)
+	}
+	for i, button := range buttons {
+		buttonName := fmt.Sprintf("%s button %d", name, i)
+		if button.Title == "" {
+			return invalidMessage("%s has no title", buttonName)
+		}
+		if err := checkLength(buttonName+" title", button.Title, MaxButtonTitleLength); err != nil {
+			return err
+		}
+		switch button.Type {
+		case ButtonWebURL:
+			if button.URL == "" {
+				return invalidMessage("%s has no url", buttonName)
+			}
+		case ButtonPostback, ButtonPhoneNumber:
+			if button.Payload == "" {
+				return invalidMessage("%s has no payload", buttonName)
+			}
+			if err := checkLength(buttonName+" payload", button.Payload, MaxPayloadLength); err != nil {
+				return err
+			}
+		default:
+			return invalidMessage("%s has unknown type %q", buttonName, button.Type)
+		}
+	}
+	return nil
+}
+
+// checkLength counts characters rather than bytes, as Messenger does.
+func checkLength(name string, value string, max int) error {
+	if n := utf8.RuneCountInString(value); n > max {
+		return invalidMessage("%s has %d characters, the limit is %d", name, n, max)
+	}
+	return nil
+}
+
+func invalidMessage(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidMessage, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,93 @@
+package messenger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageBuildersMarshal(t *testing.T) {
+	message := NewButtonTemplate("What do you need help with?",
+		PostbackButton("Shipping", "TOPIC_SHIPPING"),
+		URLButton("Track order", "https://example.com/track"),
+	).WithQuickReplies(TextQuickReply("Talk to us", "HUMAN"))
+	require.NoError(t, message.Validate())
+
+	data, err := json.Marshal(message)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"attachment": {
+			"type": "template",
+			"payload": {
+				"template_type": "button",
+				"text": "What do you need help with?",
+				"buttons": [
+					{"type": "postback", "title": "Shipping", "payload": "TOPIC_SHIPPING"},
+					{"type": "web_url", "title": "Track order", "url": "https://example.com/track"}
+				]
+			}
+		},
+		"quick_replies": [{"content_type": "text", "title": "Talk to us", "payload": "HUMAN"}]
+	}`, string(data))
+
+	data, err = json.Marshal(NewAttachmentIDMessage(AttachmentTypeImage, "1857777774821032"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"attachment": {"type": "image", "payload": {"attachment_id": "1857777774821032"}}}`, string(data))
+}
+
+func TestMessageValidate(t *testing.T) {
+	manyReplies := make([]QuickReply, MaxQuickReplies+1)
+	for i := range manyReplies {
+		manyReplies[i] = TextQuickReply(fmt.Sprintf("Option %d", i), fmt.Sprintf("OPTION_%d", i))
+	}
+	manyElements := make([]Element, MaxGenericElements+1)
+	for i := range manyElements {
+		manyElements[i] = Element{Title: "Product"}
+	}
+	button := PostbackButton("Buy", "BUY")
+
+	testCases := []struct {
+		name    string
+		message *Message
+		valid   bool
+	}{
+		{"Text", NewTextMessage("hello"), true},
+		{"Empty", &Message{}, false},
+		{"TextAndAttachment", &Message{Text: "hi", Attachment: &Attachment{Type: AttachmentTypeImage}}, false},
+		{"LongText", NewTextMessage(strings.Repeat("a", MaxTextLength+1)), false},
+		{"CJKTextAtLimit", NewTextMessage(strings.Repeat("你", MaxTextLength)), true},
+		{"QuickReplies", NewTextMessage("Pick one").WithQuickReplies(manyReplies[:MaxQuickReplies]...), true},
+		{"TooManyQuickReplies", NewTextMessage("Pick one").WithQuickReplies(manyReplies...), false},
+		{"LongQuickReplyTitle", NewTextMessage("Pick").WithQuickReplies(TextQuickReply(strings.Repeat("a", 21), "A")), false},
+		{"ImageURL", NewAttachmentMessage(AttachmentTypeImage, "https://example.com/a.png"), true},
+		{"ImageWithoutSource", NewAttachmentMessage(AttachmentTypeImage, ""), false},
+		{"UnknownAttachment", NewAttachmentMessage("sticker", "https://example.com/a.png"), false},
+		{"ButtonTemplateNoButtons", NewButtonTemplate("text"), false},
+		{"ButtonTemplateTooManyButtons", NewButtonTemplate("text", button, button, button, button), false},
+		{"ButtonTemplateLongText", NewButtonTemplate(strings.Repeat("a", MaxButtonTemplateText+1), button), false},
+		{"ButtonWithoutURL", NewButtonTemplate("text", URLButton("Open", "")), false},
+		{"ButtonLongTitle", NewButtonTemplate("text", PostbackButton(strings.Repeat("a", 21), "A")), false},
+		{"Generic", NewGenericTemplate(Element{Title: "Product", Subtitle: "In stock", ImageURL: "https://example.com/p.png", Buttons: []Button{button}}), true},
+		{"GenericTooManyElements", NewGenericTemplate(manyElements...), false},
+		{"GenericLongTitle", NewGenericTemplate(Element{Title: strings.Repeat("a", MaxElementTitleLength+1)}), false},
+		{"GenericNoTitle", NewGenericTemplate(Element{Subtitle: "In stock"}), false},
+		{"Media", NewMediaTemplate(Element{MediaType: AttachmentTypeVideo, URL: "https://www.facebook.com/page/videos/1", Buttons: []Button{button}}), true},
+		{"MediaBadType", NewMediaTemplate(Element{MediaType: AttachmentTypeFile, URL: "https://example.com/a.pdf"}), false},
+		{"MediaBothSources", NewMediaTemplate(Element{MediaType: AttachmentTypeImage, URL: "https://example.com/a.png", AttachmentID: "1"}), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.message.Validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidMessage)
+			}
+		})
+	}
+}
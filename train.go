@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/qew21/fb-messenger/analysis"
+)
+
+// runTrain trains the built-in sentiment model from labeled examples,
+// reports how it does on held-out examples and saves it for the server.
+func runTrain(args []string) int {
+	flags := flag.NewFlagSet("train", flag.ContinueOnError)
+	output := flags.String("o", appConfig.SentimentModel, "where to write the trained model")
+	testPath := flags.String("test", "", "evaluate on this file instead of a holdout of the training data")
+	holdout := flags.Float64("holdout", 0.2, "fraction of the training data held out for evaluation")
+	seed := flags.Int64("seed", 1, "seed for shuffling before the holdout split")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: train [-o model.json] [-test file | -holdout 0.2] examples.csv|examples.jsonl")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || *holdout < 0 || *holdout >= 1 {
+		flags.Usage()
+		return 2
+	}
+
+	examples, err := analysis.LoadExamples(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	train, test := examples, []analysis.Example(nil)
+	if *testPath != "" {
+		test, err = analysis.LoadExamples(*testPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else if *holdout > 0 {
+		train, test = analysis.SplitExamples(examples, *holdout, *seed)
+	}
+	if len(train) == 0 {
+		fmt.Fprintln(os.Stderr, "no training examples")
+		return 1
+	}
+
+	model := analysis.NewNaiveBayes()
+	model.Train(train)
+	fmt.Printf("trained on %d examples, classes: %v\n", len(train), model.Classes())
+
+	if len(test) > 0 {
+		evaluation, err := analysis.Evaluate(context.Background(), model, test)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println()
+		evaluation.Report(os.Stdout)
+		fmt.Println()
+	}
+
+	if err := model.Save(*output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("saved model to %s\n", *output)
+	return 0
+}
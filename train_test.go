@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/qew21/fb-messenger/analysis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunTrain(t *testing.T) {
+	setupTestConfiguration()
+	dir := t.TempDir()
+
+	var data strings.Builder
+	data.WriteString("text,label\n")
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&data, "\"great product %d, love it\",positive\n", i)
+		fmt.Fprintf(&data, "\"terrible product %d, broken\",negative\n", i)
+	}
+	examples := filepath.Join(dir, "examples.csv")
+	require.NoError(t, ioutil.WriteFile(examples, []byte(data.String()), 0644))
+	modelPath := filepath.Join(dir, "model.json")
+
+	assert.Equal(t, 0, runCommand("train", []string{"-o", modelPath, "-holdout", "0.3", examples}))
+
+	model, err := analysis.LoadNaiveBayes(modelPath)
+	require.NoError(t, err)
+	result, err := model.Analyze(context.Background(), "love this great product")
+	require.NoError(t, err)
+	assert.Equal(t, analysis.Positive, result.Prediction)
+
+	assert.Equal(t, 2, runCommand("train", nil))
+	assert.Equal(t, 1, runCommand("train", []string{"-o", modelPath, filepath.Join(dir, "missing.csv")}))
+}
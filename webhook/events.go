@@ -0,0 +1,234 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	ObjectPage = "page"
+
+	FieldFeed    = "feed"
+	FieldRatings = "ratings"
+	FieldMention = "mention"
+)
+
+// Feed change items and verbs.
+const (
+	FeedItemComment  = "comment"
+	FeedItemReaction = "reaction"
+	FeedItemStatus   = "status"
+	FeedItemPost     = "post"
+	FeedItemPhoto    = "photo"
+	FeedItemVideo    = "video"
+	FeedItemShare    = "share"
+
+	VerbAdd    = "add"
+	VerbEdit   = "edit"
+	VerbEdited = "edited"
+	VerbRemove = "remove"
+	VerbHide   = "hide"
+	VerbUnhide = "unhide"
+)
+
+// Update is the body of a webhook POST.
+type Update struct {
+	Object string  `json:"object"`
+	Entry  []Entry `json:"entry"`
+}
+
+// Entry holds the events for a single page. Messenger subscriptions fill
+// Messaging, page field subscriptions fill Changes.
+type Entry struct {
+	ID        string           `json:"id"`
+	Time      int64            `json:"time"`
+	Messaging []MessagingEvent `json:"messaging,omitempty"`
+	Changes   []Change         `json:"changes,omitempty"`
+}
+
+type User struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// MessagingEvent is one element of entry.messaging. Exactly one of the
+// pointer fields is normally set.
+type MessagingEvent struct {
+	Sender    User      `json:"sender"`
+	Recipient User      `json:"recipient"`
+	Timestamp int64     `json:"timestamp"`
+	Message   *Message  `json:"message,omitempty"`
+	Postback  *Postback `json:"postback,omitempty"`
+	Delivery  *Delivery `json:"delivery,omitempty"`
+	Read      *Read     `json:"read,omitempty"`
+}
+
+type Message struct {
+	Mid         string       `json:"mid"`
+	Text        string       `json:"text,omitempty"`
+	IsEcho      bool         `json:"is_echo,omitempty"`
+	AppID       int64        `json:"app_id,omitempty"`
+	Metadata    string       `json:"metadata,omitempty"`
+	QuickReply  *QuickReply  `json:"quick_reply,omitempty"`
+	ReplyTo     *ReplyTo     `json:"reply_to,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+type QuickReply struct {
+	Payload string `json:"payload"`
+}
+
+type ReplyTo struct {
+	Mid string `json:"mid"`
+}
+
+const (
+	AttachmentImage    = "image"
+	AttachmentAudio    = "audio"
+	AttachmentVideo    = "video"
+	AttachmentFile     = "file"
+	AttachmentLocation = "location"
+	AttachmentSticker  = "sticker"
+	AttachmentFallback = "fallback"
+)
+
+type Attachment struct {
+	Type    string            `json:"type"`
+	Title   string            `json:"title,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Payload AttachmentPayload `json:"payload"`
+}
+
+// AttachmentPayload holds the fields of every inbound attachment type; which
+// ones are set depends on Attachment.Type.
+type AttachmentPayload struct {
+	URL         string       `json:"url,omitempty"`
+	Title       string       `json:"title,omitempty"`
+	StickerID   int64        `json:"sticker_id,omitempty"`
+	Coordinates *Coordinates `json:"coordinates,omitempty"`
+}
+
+type Coordinates struct {
+	Lat  float64 `json:"lat"`
+	Long float64 `json:"long"`
+}
+
+// Kind returns the attachment type, reporting stickers (delivered as images
+// with a sticker_id) as AttachmentSticker.
+func (a Attachment) Kind() string {
+	if a.Payload.StickerID != 0 {
+		return AttachmentSticker
+	}
+	return a.Type
+}
+
+type Postback struct {
+	Mid     string `json:"mid,omitempty"`
+	Title   string `json:"title"`
+	Payload string `json:"payload"`
+}
+
+type Delivery struct {
+	Mids      []string `json:"mids,omitempty"`
+	Watermark int64    `json:"watermark"`
+}
+
+type Read struct {
+	Watermark int64 `json:"watermark"`
+}
+
+// Change is one element of entry.changes. Value is kept raw until the
+// caller asks for the type matching Field.
+type Change struct {
+	Field string          `json:"field"`
+	Value json.RawMessage `json:"value"`
+}
+
+type FeedValue struct {
+	Item         string `json:"item"`
+	Verb         string `json:"verb"`
+	PostID       string `json:"post_id"`
+	CommentID    string `json:"comment_id,omitempty"`
+	ParentID     string `json:"parent_id,omitempty"`
+	Message      string `json:"message,omitempty"`
+	ReactionType string `json:"reaction_type,omitempty"`
+	Link         string `json:"link,omitempty"`
+	PhotoID      string `json:"photo_id,omitempty"`
+	VideoID      string `json:"video_id,omitempty"`
+	Published    int    `json:"published,omitempty"`
+	CreatedTime  int64  `json:"created_time"`
+	From         User   `json:"from"`
+}
+
+type RatingsValue struct {
+	Item               string `json:"item"`
+	Verb               string `json:"verb"`
+	CommentID          string `json:"comment_id"`
+	OpenGraphStoryID   string `json:"open_graph_story_id"`
+	Rating             int    `json:"rating,omitempty"`
+	RecommendationType string `json:"recommendation_type"`
+	ReviewText         string `json:"review_text,omitempty"`
+	ReviewerID         string `json:"reviewer_id"`
+	ReviewerName       string `json:"reviewer_name,omitempty"`
+	CreatedTime        int64  `json:"created_time"`
+}
+
+type MentionValue struct {
+	Item      string `json:"item"`
+	Verb      string `json:"verb"`
+	PostID    string `json:"post_id"`
+	CommentID string `json:"comment_id,omitempty"`
+}
+
+// Decode parses a webhook body. Unlike unmarshalling into a map it never
+// panics on an unexpected shape; the returned error names the offending field.
+// Individual events are not validated here so that one malformed event does
+// not make Facebook redeliver, and the processor skip, the rest of the batch.
+func Decode(data []byte) (*Update, error) {
+	var update Update
+	if err := json.Unmarshal(data, &update); err != nil {
+		return nil, describeError("update", err)
+	}
+	if update.Object == "" {
+		return nil, fmt.Errorf("invalid update: missing object")
+	}
+
+	return &update, nil
+}
+
+func (c Change) Feed() (*FeedValue, error) {
+	var value FeedValue
+	return &value, c.decodeValue(FieldFeed, &value)
+}
+
+func (c Change) Ratings() (*RatingsValue, error) {
+	var value RatingsValue
+	return &value, c.decodeValue(FieldRatings, &value)
+}
+
+func (c Change) Mention() (*MentionValue, error) {
+	var value MentionValue
+	return &value, c.decodeValue(FieldMention, &value)
+}
+
+func (c Change) decodeValue(field string, v interface{}) error {
+	if c.Field != field {
+		return fmt.Errorf("change field is %q, not %q", c.Field, field)
+	}
+	if len(c.Value) == 0 {
+		return fmt.Errorf("%s change has no value", field)
+	}
+	if err := json.Unmarshal(c.Value, v); err != nil {
+		return describeError(field+" change", err)
+	}
+	return nil
+}
+
+func describeError(what string, err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("invalid %s: field %q should be %s, got %s: %w", what, typeErr.Field, typeErr.Type, typeErr.Value, err)
+	}
+	return fmt.Errorf("invalid %s: %w", what, err)
+}
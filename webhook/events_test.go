@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readMock(t *testing.T, filename string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("..", "mock", filename))
+	require.NoError(t, err)
+	return data
+}
+
+func TestDecodeMocks(t *testing.T) {
+	update, err := Decode(readMock(t, "message.json"))
+	require.NoError(t, err)
+	assert.Equal(t, ObjectPage, update.Object)
+	require.Len(t, update.Entry[0].Messaging, 1)
+	event := update.Entry[0].Messaging[0]
+	assert.Equal(t, "44444444", event.Sender.ID)
+	assert.Equal(t, "Ukz9Pxgdg", event.Message.Mid)
+	assert.Equal(t, "hello", event.Message.Text)
+
+	update, err = Decode(readMock(t, "image.json"))
+	require.NoError(t, err)
+	message := update.Entry[0].Messaging[0].Message
+	assert.Empty(t, message.Text)
+	require.Len(t, message.Attachments, 1)
+	assert.Equal(t, "image", message.Attachments[0].Type)
+	assert.Equal(t, "https://example.com/image.png", message.Attachments[0].Payload.URL)
+
+	update, err = Decode(readMock(t, "feed.json"))
+	require.NoError(t, err)
+	feed, err := update.Entry[0].Changes[0].Feed()
+	require.NoError(t, err)
+	assert.Equal(t, "44444444_444444444", feed.PostID)
+	assert.Equal(t, "Example post content.", feed.Message)
+	assert.Equal(t, "1067280970047460", feed.From.ID)
+
+	update, err = Decode(readMock(t, "ratings.json"))
+	require.NoError(t, err)
+	ratings, err := update.Entry[0].Changes[0].Ratings()
+	require.NoError(t, err)
+	assert.Equal(t, "POSITIVE", ratings.RecommendationType)
+	assert.Equal(t, 4, ratings.Rating)
+
+	_, err = update.Entry[0].Changes[0].Feed()
+	assert.Error(t, err)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	testCases := []struct {
+		name    string
+		payload string
+		message string
+	}{
+		{"Syntax", `{"object":`, "invalid update"},
+		{"MissingObject", `{"entry":[]}`, "missing object"},
+		{"WrongType", `{"object":"page","entry":[{"messaging":[{"sender":{"id":"1"},"message":{"text":5}}]}]}`, "message.text"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode([]byte(tc.payload))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.message)
+		})
+	}
+}
+
+func TestChangeValueInvalid(t *testing.T) {
+	change := Change{Field: FieldFeed, Value: []byte(`{"message":["not","text"]}`)}
+	_, err := change.Feed()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"message"`)
+
+	_, err = Change{Field: FieldFeed}.Feed()
+	assert.Error(t, err)
+}
@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+const (
+	SignatureHeader       = "X-Hub-Signature-256"
+	LegacySignatureHeader = "X-Hub-Signature"
+)
+
+var (
+	ErrMissingSecret    = errors.New("app secret is not configured")
+	ErrMissingSignature = errors.New("request has no signature header")
+	ErrInvalidSignature = errors.New("signature does not match payload")
+)
+
+// VerifySignature checks the raw request body against the X-Hub-Signature-256
+// header, falling back to the legacy SHA1 X-Hub-Signature header when the
+// former is absent.
+func VerifySignature(appSecret string, body []byte, header http.Header) error {
+	if appSecret == "" {
+		return ErrMissingSecret
+	}
+
+	if signature := header.Get(SignatureHeader); signature != "" {
+		return compareSignature(sha256.New, "sha256=", appSecret, body, signature)
+	}
+	if signature := header.Get(LegacySignatureHeader); signature != "" {
+		return compareSignature(sha1.New, "sha1=", appSecret, body, signature)
+	}
+
+	return ErrMissingSignature
+}
+
+// Sign returns the X-Hub-Signature-256 header value for body.
+func Sign(appSecret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func compareSignature(newHash func() hash.Hash, prefix string, appSecret string, body []byte, signature string) error {
+	if !strings.HasPrefix(signature, prefix) {
+		return fmt.Errorf("%w: expected %s prefix", ErrInvalidSignature, prefix)
+	}
+
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	mac := hmac.New(newHash, []byte(appSecret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
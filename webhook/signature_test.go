@@ -0,0 +1,42 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifySignature(t *testing.T) {
+	secret := "test_secret"
+	body := []byte(`{"object":"page","entry":[]}`)
+
+	legacy := hmac.New(sha1.New, []byte(secret))
+	legacy.Write(body)
+	legacySignature := "sha1=" + hex.EncodeToString(legacy.Sum(nil))
+
+	testCases := []struct {
+		name     string
+		secret   string
+		header   http.Header
+		expected error
+	}{
+		{"SHA256", secret, http.Header{SignatureHeader: {Sign(secret, body)}}, nil},
+		{"LegacySHA1", secret, http.Header{LegacySignatureHeader: {legacySignature}}, nil},
+		{"WrongSecret", secret, http.Header{SignatureHeader: {Sign("other", body)}}, ErrInvalidSignature},
+		{"WrongPrefix", secret, http.Header{SignatureHeader: {legacySignature}}, ErrInvalidSignature},
+		{"NotHex", secret, http.Header{SignatureHeader: {"sha256=zz"}}, ErrInvalidSignature},
+		{"Missing", secret, http.Header{}, ErrMissingSignature},
+		{"NoSecret", "", http.Header{SignatureHeader: {Sign(secret, body)}}, ErrMissingSecret},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifySignature(tc.secret, body, tc.header)
+			assert.ErrorIs(t, err, tc.expected)
+		})
+	}
+}